@@ -0,0 +1,301 @@
+// Package admin exposes REST endpoints to manage sessions of an apisession.ISessionManager
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	apisession "github.com/zeroboo/go-api-session"
+)
+
+// Action is what a request does, passed to Authorizer
+type Action string
+
+const (
+	ActionGetSession    Action = "session.get"
+	ActionDeleteSession Action = "session.delete"
+	ActionResetCounters Action = "session.reset"
+	ActionUpdatePayload Action = "session.payload"
+	ActionGrantQuota    Action = "session.grant"
+	ActionGetOnline     Action = "online.get"
+)
+
+// ErrUnauthorized is returned by Authorizer when the request has no valid credentials, it is answered with 401.
+// Other errors are answered with 403.
+var ErrUnauthorized = fmt.Errorf("unauthorized")
+
+// Authorizer allows a request to do an action, owner is empty for actions not about a session.
+// Returns nil to allow.
+type Authorizer func(r *http.Request, action Action, owner string) error
+
+// BearerToken allows requests with header `Authorization: Bearer <token>`
+func BearerToken(token string) Authorizer {
+	return func(r *http.Request, action Action, owner string) error {
+		requestToken, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(requestToken), []byte(token)) != 1 {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+}
+
+// Handler serves admin endpoints:
+//
+//	GET    /sessions/{owner}          session as APISession json
+//	DELETE /sessions/{owner}          delete session
+//	POST   /sessions/{owner}/reset    reset api call counters, body {"url": "..."} resets one url only
+//	PATCH  /sessions/{owner}/payload  set payload keys of body object, null values delete keys
+//	POST   /sessions/{owner}/grants   grant extra calls, body {"url": "...", "calls": 100, "seconds": 3600}
+//	                                  url is optional
+//	GET    /online                    online users with their last activity in milliseconds
+//
+// Changes use atomic methods of the manager when it has them, also through metrics and tracing wrappers.
+// Resets and grants need apisession.CounterResetter and apisession.QuotaGranter, they are answered with 501
+// by managers without them. Payloads of other managers are updated by loading and saving sessions.
+// Errors are answered as {"error": "..."}. Mount it under a path with http.StripPrefix.
+type Handler struct {
+	manager   apisession.ISessionManager
+	authorize Authorizer
+	mux       *http.ServeMux
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+// Creates an admin handler. Cache invalidation of manager is enabled, so changes made here
+// reach instances with session cache.
+//
+// Params:
+//   - manager: manager of the sessions
+//   - authorize: authorizes every request, if nil all requests are forbidden
+func NewHandler(manager apisession.ISessionManager, authorize Authorizer) *Handler {
+	if invalidator, ok := manager.(apisession.CacheInvalidator); ok {
+		invalidator.EnableCacheInvalidation()
+	}
+	h := &Handler{
+		manager:   manager,
+		authorize: authorize,
+		mux:       http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /sessions/{owner}", h.getSession)
+	h.mux.HandleFunc("DELETE /sessions/{owner}", h.deleteSession)
+	h.mux.HandleFunc("POST /sessions/{owner}/reset", h.resetCounters)
+	h.mux.HandleFunc("PATCH /sessions/{owner}/payload", h.updatePayload)
+	h.mux.HandleFunc("POST /sessions/{owner}/grants", h.grantQuota)
+	h.mux.HandleFunc("GET /online", h.getOnline)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// Answers error of manager, returns true if err is not nil
+func writeManagerError(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, redis.Nil) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("session not found"))
+	} else if errors.Is(err, apisession.ErrConflict) {
+		writeError(w, http.StatusConflict, err)
+	} else if errors.Is(err, errors.ErrUnsupported) {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("not supported by session manager"))
+	} else {
+		writeError(w, http.StatusInternalServerError, err)
+	}
+	return true
+}
+
+// Authorizes request, answers and returns false if not allowed
+func (h *Handler) allow(w http.ResponseWriter, r *http.Request, action Action, owner string) bool {
+	if h.authorize == nil {
+		writeError(w, http.StatusForbidden, fmt.Errorf("forbidden"))
+		return false
+	}
+	errAuthorize := h.authorize(r, action, owner)
+	if errAuthorize == nil {
+		return true
+	}
+	if errors.Is(errAuthorize, ErrUnauthorized) {
+		writeError(w, http.StatusUnauthorized, errAuthorize)
+	} else {
+		writeError(w, http.StatusForbidden, errAuthorize)
+	}
+	return false
+}
+
+func (h *Handler) getSession(w http.ResponseWriter, r *http.Request) {
+	owner := r.PathValue("owner")
+	if !h.allow(w, r, ActionGetSession, owner) {
+		return
+	}
+	h.writeSession(w, r, owner)
+}
+
+func (h *Handler) deleteSession(w http.ResponseWriter, r *http.Request) {
+	owner := r.PathValue("owner")
+	if !h.allow(w, r, ActionDeleteSession, owner) {
+		return
+	}
+	if writeManagerError(w, h.manager.DeleteSession(r.Context(), owner)) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type resetRequest struct {
+	//Url to reset, all urls if empty
+	URL string `json:"url"`
+}
+
+func (h *Handler) resetCounters(w http.ResponseWriter, r *http.Request) {
+	owner := r.PathValue("owner")
+	if !h.allow(w, r, ActionResetCounters, owner) {
+		return
+	}
+	request := resetRequest{}
+	if r.ContentLength != 0 {
+		errDecode := json.NewDecoder(r.Body).Decode(&request)
+		if errDecode != nil {
+			writeError(w, http.StatusBadRequest, errDecode)
+			return
+		}
+	}
+
+	errReset := errors.ErrUnsupported
+	if resetter, ok := h.manager.(apisession.CounterResetter); ok {
+		errReset = resetter.ResetCounters(r.Context(), owner, request.URL)
+	}
+	if writeManagerError(w, errReset) {
+		return
+	}
+	h.writeSession(w, r, owner)
+}
+
+type grantRequest struct {
+	//Url of the grant, all urls if empty
+	URL     string `json:"url"`
+	Calls   int64  `json:"calls"`
+	Seconds int64  `json:"seconds"`
+}
+
+func (h *Handler) grantQuota(w http.ResponseWriter, r *http.Request) {
+	owner := r.PathValue("owner")
+	if !h.allow(w, r, ActionGrantQuota, owner) {
+		return
+	}
+	request := grantRequest{}
+	errDecode := json.NewDecoder(r.Body).Decode(&request)
+	if errDecode != nil {
+		writeError(w, http.StatusBadRequest, errDecode)
+		return
+	}
+	if request.Calls <= 0 || request.Seconds <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("calls and seconds must be positive"))
+		return
+	}
+	duration := time.Duration(request.Seconds) * time.Second
+	errGrant := errors.ErrUnsupported
+	if granter, ok := h.manager.(apisession.QuotaGranter); ok {
+		errGrant = granter.GrantQuota(r.Context(), owner, request.URL, request.Calls, duration)
+	}
+	if writeManagerError(w, errGrant) {
+		return
+	}
+	h.writeSession(w, r, owner)
+}
+
+// Answers current session of owner
+func (h *Handler) writeSession(w http.ResponseWriter, r *http.Request, owner string) {
+	session, errGet := h.manager.GetSession(r.Context(), owner)
+	if writeManagerError(w, errGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (h *Handler) updatePayload(w http.ResponseWriter, r *http.Request) {
+	owner := r.PathValue("owner")
+	if !h.allow(w, r, ActionUpdatePayload, owner) {
+		return
+	}
+	var changes map[string]any
+	errDecode := json.NewDecoder(r.Body).Decode(&changes)
+	if errDecode != nil {
+		writeError(w, http.StatusBadRequest, errDecode)
+		return
+	}
+	update := func(payload map[string]any) {
+		for key, value := range changes {
+			if value == nil {
+				delete(payload, key)
+			} else {
+				payload[key] = value
+			}
+		}
+	}
+
+	errUpdate := errors.ErrUnsupported
+	if updater, ok := h.manager.(apisession.PayloadUpdater); ok {
+		errUpdate = updater.UpdatePayload(r.Context(), owner, update)
+	}
+	if errors.Is(errUpdate, errors.ErrUnsupported) {
+		errUpdate = h.updateSession(r.Context(), owner, func(session *apisession.APISession) {
+			if session.Payload == nil {
+				session.Payload = make(map[string]any)
+			}
+			update(session.Payload)
+		})
+	}
+	if writeManagerError(w, errUpdate) {
+		return
+	}
+	h.writeSession(w, r, owner)
+}
+
+// Modifies session of owner atomically if the manager supports it, otherwise by loading and saving it
+func (h *Handler) updateSession(ctx context.Context, owner string, mutate func(session *apisession.APISession)) error {
+	if updater, ok := h.manager.(apisession.SessionUpdater); ok {
+		_, errUpdate := updater.UpdateSession(ctx, owner, func(session *apisession.APISession) error {
+			mutate(session)
+			return nil
+		})
+		if !errors.Is(errUpdate, errors.ErrUnsupported) {
+			return errUpdate
+		}
+	}
+	session, errGet := h.manager.GetSession(ctx, owner)
+	if errGet != nil {
+		return errGet
+	}
+	mutate(session)
+	return h.manager.SetSession(ctx, owner, session)
+}
+
+func (h *Handler) getOnline(w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r, ActionGetOnline, "") {
+		return
+	}
+	onlineUsers, errOnline := h.manager.GetOnlineUsers(r.Context())
+	if writeManagerError(w, errOnline) {
+		return
+	}
+	writeJSON(w, http.StatusOK, onlineUsers)
+}
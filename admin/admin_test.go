@@ -0,0 +1,197 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	apisession "github.com/zeroboo/go-api-session"
+	"github.com/zeroboo/go-api-session/metrics"
+	"github.com/zeroboo/go-api-session/tracing"
+)
+
+var errForbidden = fmt.Errorf("read only")
+
+// Keeps sessions in memory instead of redis
+type stubSessionManager struct {
+	apisession.ISessionManager
+	sessions    map[string]*apisession.APISession
+	onlineUsers map[string]int64
+	saves       int
+}
+
+func (s *stubSessionManager) GetSession(ctx context.Context, owner string) (*apisession.APISession, error) {
+	session, exist := s.sessions[owner]
+	if !exist {
+		return nil, redis.Nil
+	}
+	return session, nil
+}
+
+func (s *stubSessionManager) SetSession(ctx context.Context, owner string, session *apisession.APISession) error {
+	s.sessions[owner] = session
+	s.saves++
+	return nil
+}
+
+func (s *stubSessionManager) DeleteSession(ctx context.Context, owner string) error {
+	delete(s.sessions, owner)
+	return nil
+}
+
+func (s *stubSessionManager) GetOnlineUsers(ctx context.Context) (map[string]int64, error) {
+	return s.onlineUsers, nil
+}
+
+// Updates sessions atomically like RedisSessionManager, payloads are updated with UpdateSession
+type updaterSessionManager struct {
+	*stubSessionManager
+	updates int
+}
+
+func (s *updaterSessionManager) UpdateSession(ctx context.Context, owner string, mutate func(session *apisession.APISession) error) (*apisession.APISession, error) {
+	session, errGet := s.GetSession(ctx, owner)
+	if errGet != nil {
+		return nil, errGet
+	}
+	s.updates++
+	return session, mutate(session)
+}
+
+func (s *updaterSessionManager) ResetCounters(ctx context.Context, owner string, url string) error {
+	_, errUpdate := s.UpdateSession(ctx, owner, func(session *apisession.APISession) error {
+		session.ResetCounters(url)
+		return nil
+	})
+	return errUpdate
+}
+
+func (s *updaterSessionManager) GrantQuota(ctx context.Context, owner string, url string, calls int64, duration time.Duration) error {
+	_, errUpdate := s.UpdateSession(ctx, owner, func(session *apisession.APISession) error {
+		now := time.Now()
+		session.AddGrant(url, calls, now.Add(duration).UnixMilli(), now.UnixMilli())
+		return nil
+	})
+	return errUpdate
+}
+
+func serve(handler http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// go test -timeout 30s -run ^TestHandler_ManageSession$ github.com/zeroboo/go-api-session/admin -v
+func TestHandler_ManageSession(t *testing.T) {
+	session := apisession.NewAPISessionWithPayload("user1", map[string]any{"nickname": "Jaian", "level": 1})
+	session.GetCallRecord("url1").Count = 5
+	session.GetCallRecord("url2").Count = 3
+	stub := &updaterSessionManager{stubSessionManager: &stubSessionManager{
+		sessions:    map[string]*apisession.APISession{"user1": session},
+		onlineUsers: map[string]int64{"user1": 1000},
+	}}
+	handler := NewHandler(stub, BearerToken("secret"))
+
+	response := serve(handler, http.MethodGet, "/sessions/user1", "")
+	assert.Equal(t, http.StatusOK, response.Code, "Get session")
+	var loaded map[string]any
+	json.Unmarshal(response.Body.Bytes(), &loaded)
+	assert.Equal(t, session.Id, loaded["i"], "Json tags of APISession")
+
+	response = serve(handler, http.MethodPost, "/sessions/user1/reset", `{"url": "url1"}`)
+	assert.Equal(t, http.StatusOK, response.Code, "Reset url")
+	assert.Equal(t, int64(0), session.GetCallRecord("url1").Count, "url1 reset")
+	assert.Equal(t, int64(3), session.GetCallRecord("url2").Count, "url2 kept")
+	response = serve(handler, http.MethodPost, "/sessions/user1/reset", "")
+	assert.Equal(t, http.StatusOK, response.Code, "Reset all")
+	assert.Equal(t, int64(0), session.GetCallRecord("url2").Count, "url2 reset")
+
+	response = serve(handler, http.MethodPatch, "/sessions/user1/payload", `{"level": 2, "nickname": null}`)
+	assert.Equal(t, http.StatusOK, response.Code, "Update payload")
+	assert.Equal(t, map[string]any{"level": float64(2)}, session.Payload, "Payload updated")
+
+	response = serve(handler, http.MethodPost, "/sessions/user1/grants", `{"calls": 10, "seconds": 60}`)
+	assert.Equal(t, http.StatusOK, response.Code, "Grant")
+	assert.Equal(t, int64(10), session.Grants[apisession.AllURLs].Calls, "Grant added")
+
+	response = serve(handler, http.MethodGet, "/online", "")
+	assert.Equal(t, http.StatusOK, response.Code, "Get online users")
+	assert.JSONEq(t, `{"user1": 1000}`, response.Body.String(), "Online users")
+
+	response = serve(handler, http.MethodDelete, "/sessions/user1", "")
+	assert.Equal(t, http.StatusNoContent, response.Code, "Delete session")
+	response = serve(handler, http.MethodGet, "/sessions/user1", "")
+	assert.Equal(t, http.StatusNotFound, response.Code, "Deleted session not found")
+}
+
+// go test -timeout 30s -run ^TestHandler_WrappedManager_UpdatesAtomically$ github.com/zeroboo/go-api-session/admin -v
+func TestHandler_WrappedManager_UpdatesAtomically(t *testing.T) {
+	session := apisession.NewAPISession("user1")
+	session.GetCallRecord("url1").Count = 5
+	stub := &updaterSessionManager{stubSessionManager: &stubSessionManager{
+		sessions: map[string]*apisession.APISession{"user1": session},
+	}}
+	wrapped, errWrap := metrics.NewInstrumentedSessionManager(tracing.NewTracedSessionManager(stub, nil), prometheus.NewRegistry(), metrics.Options{})
+	assert.Nil(t, errWrap, "Wrap manager, no error")
+	handler := NewHandler(wrapped, BearerToken("secret"))
+
+	response := serve(handler, http.MethodPost, "/sessions/user1/reset", "")
+	assert.Equal(t, http.StatusOK, response.Code, "Reset")
+	assert.Equal(t, int64(0), session.GetCallRecord("url1").Count, "url1 reset")
+	response = serve(handler, http.MethodPost, "/sessions/user1/grants", `{"url": "url1", "calls": 10, "seconds": 60}`)
+	assert.Equal(t, http.StatusOK, response.Code, "Grant")
+	response = serve(handler, http.MethodPatch, "/sessions/user1/payload", `{"level": 2}`)
+	assert.Equal(t, http.StatusOK, response.Code, "Update payload")
+
+	assert.Equal(t, 3, stub.updates, "Updated with UpdateSession")
+	assert.Equal(t, 0, stub.saves, "No load and save")
+}
+
+// go test -timeout 30s -run ^TestHandler_Unsupported_NotImplemented$ github.com/zeroboo/go-api-session/admin -v
+func TestHandler_Unsupported_NotImplemented(t *testing.T) {
+	session := apisession.NewAPISession("user1")
+	session.GetCallRecord("url1").Count = 5
+	stub := &stubSessionManager{sessions: map[string]*apisession.APISession{"user1": session}}
+	handler := NewHandler(tracing.NewTracedSessionManager(stub, nil), BearerToken("secret"))
+
+	response := serve(handler, http.MethodPost, "/sessions/user1/reset", "")
+	assert.Equal(t, http.StatusNotImplemented, response.Code, "Reset not supported")
+	assert.Equal(t, int64(5), session.GetCallRecord("url1").Count, "Counters kept")
+	response = serve(handler, http.MethodPost, "/sessions/user1/grants", `{"calls": 10, "seconds": 60}`)
+	assert.Equal(t, http.StatusNotImplemented, response.Code, "Grant not supported")
+	assert.Empty(t, session.Grants, "No grant")
+
+	response = serve(handler, http.MethodPatch, "/sessions/user1/payload", `{"level": 2}`)
+	assert.Equal(t, http.StatusOK, response.Code, "Update payload")
+	assert.Equal(t, 1, stub.saves, "Payload loaded and saved")
+}
+
+// go test -timeout 30s -run ^TestHandler_Authorization$ github.com/zeroboo/go-api-session/admin -v
+func TestHandler_Authorization(t *testing.T) {
+	stub := &stubSessionManager{sessions: map[string]*apisession.APISession{}}
+
+	response := serve(NewHandler(stub, BearerToken("other")), http.MethodGet, "/sessions/user1", "")
+	assert.Equal(t, http.StatusUnauthorized, response.Code, "Wrong token")
+
+	readOnly := func(r *http.Request, action Action, owner string) error {
+		if action != ActionGetSession {
+			return errForbidden
+		}
+		return nil
+	}
+	response = serve(NewHandler(stub, readOnly), http.MethodDelete, "/sessions/user1", "")
+	assert.Equal(t, http.StatusForbidden, response.Code, "Action not allowed")
+
+	response = serve(NewHandler(stub, nil), http.MethodGet, "/sessions/user1", "")
+	assert.Equal(t, http.StatusForbidden, response.Code, "No authorizer")
+}
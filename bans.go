@@ -0,0 +1,151 @@
+package apisession
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Ban cuts an owner off until it expires or is lifted
+type Ban struct {
+	Owner   string `json:"o"`
+	Reason  string `json:"r"`
+	Created int64  `json:"c"` //Created time in milliseconds
+	Expires int64  `json:"e"` //Expired time in milliseconds, 0 if permanent
+}
+
+// BanError is returned for calls of a banned owner, it matches ErrBanned with errors.Is
+type BanError struct {
+	Ban *Ban
+}
+
+func (e *BanError) Error() string {
+	if e.Ban.Reason == "" {
+		return ErrBanned.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrBanned.Error(), e.Ban.Reason)
+}
+
+func (e *BanError) Unwrap() error { return ErrBanned }
+
+// EnableBans makes RecordAPICall and starting sessions reject banned owners.
+// Bans are stored in redis, so they apply to all instances enabling them.
+// Checking a ban costs one more redis call per api call.
+// Should be called before the manager is in use.
+func (sm *RedisSessionManager) EnableBans() {
+	sm.bansEnabled = true
+}
+
+// Returns key of owner ban, it doesn't start with session prefix so it is never read as a session
+func (sm *RedisSessionManager) getBanKey(owner string) string {
+	return fmt.Sprintf("ban:%s:%s", sm.sessionKeyPrefix, owner)
+}
+
+// BanOwner bans an owner and deletes the owner's session. Banning a banned owner replaces the ban.
+//
+// Params:
+//   - reason: reason returned in BanError
+//   - duration: ban duration, 0 means permanent
+func (sm *RedisSessionManager) BanOwner(ctx context.Context, owner string, reason string, duration time.Duration) (*Ban, error) {
+	if !sm.bansEnabled {
+		return nil, fmt.Errorf("bans are disabled")
+	}
+	now := time.Now()
+	ban := &Ban{
+		Owner:   owner,
+		Reason:  reason,
+		Created: now.UnixMilli(),
+	}
+	if duration > 0 {
+		ban.Expires = now.Add(duration).UnixMilli()
+	}
+	value, errMarshal := json.Marshal(ban)
+	if errMarshal != nil {
+		return nil, errMarshal
+	}
+	errSet := sm.redisClient.Set(ctx, sm.getBanKey(owner), value, duration).Err()
+	if errSet != nil {
+		sm.logRedisError(ctx, "BanOwner", owner, errSet)
+		return nil, errSet
+	}
+	sm.logger.InfoContext(ctx, "owner banned", "owner", owner, "reason", reason, "duration", duration)
+
+	errDelete := sm.DeleteSession(ctx, owner)
+	if errDelete != nil {
+		return nil, errDelete
+	}
+	return ban, nil
+}
+
+// UnbanOwner lifts ban of an owner, lifting a missing ban is not an error
+func (sm *RedisSessionManager) UnbanOwner(ctx context.Context, owner string) error {
+	errDel := sm.redisClient.Del(ctx, sm.getBanKey(owner)).Err()
+	if errDel != nil {
+		sm.logRedisError(ctx, "UnbanOwner", owner, errDel)
+		return errDel
+	}
+	return nil
+}
+
+// GetBan returns ban of an owner, redis.Nil if not banned
+func (sm *RedisSessionManager) GetBan(ctx context.Context, owner string) (*Ban, error) {
+	value, errGet := sm.redisClient.Get(ctx, sm.getBanKey(owner)).Bytes()
+	if errGet != nil {
+		return nil, errGet
+	}
+	return parseBan(value)
+}
+
+func parseBan(value []byte) (*Ban, error) {
+	ban := &Ban{}
+	errUnmarshal := json.Unmarshal(value, ban)
+	if errUnmarshal != nil {
+		return nil, &decodeError{errUnmarshal}
+	}
+	return ban, nil
+}
+
+// ListBans returns active bans, keys are read with SCAN so redis is not blocked
+func (sm *RedisSessionManager) ListBans(ctx context.Context) ([]*Ban, error) {
+	bans := []*Ban{}
+	keyPrefix := sm.getBanKey("")
+	iter := sm.redisClient.Scan(ctx, 0, escapeGlob(keyPrefix)+"*", defaultScanCount).Iterator()
+	for iter.Next(ctx) {
+		ban, errGet := sm.GetBan(ctx, strings.TrimPrefix(iter.Val(), keyPrefix))
+		if errors.Is(errGet, redis.Nil) {
+			//Expired after SCAN
+			continue
+		}
+		if errGet != nil {
+			sm.logLoadError(ctx, "ListBans", "", errGet)
+			return nil, errGet
+		}
+		bans = append(bans, ban)
+	}
+	if iter.Err() != nil {
+		sm.logRedisError(ctx, "ListBans", "", iter.Err())
+		return nil, iter.Err()
+	}
+	return bans, nil
+}
+
+// Returns BanError if bans are enabled and owner is banned
+func (sm *RedisSessionManager) checkBan(ctx context.Context, owner string) error {
+	if !sm.bansEnabled {
+		return nil
+	}
+	ban, errGet := sm.GetBan(ctx, owner)
+	if errors.Is(errGet, redis.Nil) {
+		return nil
+	}
+	if errGet != nil {
+		sm.logLoadError(ctx, "CheckBan", owner, errGet)
+		return errGet
+	}
+	return &BanError{Ban: ban}
+}
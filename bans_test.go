@@ -0,0 +1,56 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestBans_BannedOwnerRejected$ github.com/zeroboo/go-api-session -v
+func TestBans_BannedOwnerRejected(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	manager.EnableBans()
+	defer manager.UnbanOwner(context.TODO(), owner)
+	sessionId, _ := manager.StartSession(context.TODO(), owner)
+	sessionOwners = append(sessionOwners, owner)
+
+	ban, errBan := manager.BanOwner(context.TODO(), owner, "spam", time.Minute)
+	assert.Nil(t, errBan, "Ban, no error")
+	assert.Equal(t, "spam", ban.Reason, "Ban reason")
+
+	//Other instances see the ban
+	other := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	other.EnableBans()
+	_, errRecord := other.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	assert.True(t, errors.Is(errRecord, ErrBanned), "Call rejected")
+	var banError *BanError
+	assert.True(t, errors.As(errRecord, &banError), "Ban details")
+	assert.Equal(t, "spam", banError.Ban.Reason, "Ban reason")
+	_, errStart := other.StartSession(context.TODO(), owner)
+	assert.True(t, errors.Is(errStart, ErrBanned), "Start rejected")
+	_, errGet := manager.GetSession(context.TODO(), owner)
+	assert.Equal(t, redis.Nil, errGet, "Session deleted")
+
+	bans, errList := manager.ListBans(context.TODO())
+	assert.Nil(t, errList, "List bans, no error")
+	assert.Contains(t, bans, ban, "Ban listed")
+
+	errUnban := manager.UnbanOwner(context.TODO(), owner)
+	assert.Nil(t, errUnban, "Unban, no error")
+	sessionId, errStart = manager.StartSession(context.TODO(), owner)
+	assert.Nil(t, errStart, "Start after unban")
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	assert.Nil(t, errRecord, "Call after unban")
+}
+
+// go test -timeout 30s -run ^TestBans_Disabled$ github.com/zeroboo/go-api-session -v
+func TestBans_Disabled(t *testing.T) {
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	_, errBan := manager.BanOwner(context.TODO(), "user_"+t.Name(), "spam", 0)
+	assert.NotNil(t, errBan, "Bans disabled, error")
+}
@@ -0,0 +1,225 @@
+package apisession
+
+import (
+	"context"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// SessionResult is the result of one owner in batch operations
+type SessionResult struct {
+	Owner string
+	//Loaded or saved session, nil if Err is not nil or operation is a delete
+	Session *APISession
+	//redis.Nil if session doesn't exist
+	Err error
+}
+
+// GetSessions loads sessions of many owners in one round trip per storage layout.
+//
+// Returns:
+//   - []SessionResult: result of each owner, in order of owners
+func (sm *RedisSessionManager) GetSessions(ctx context.Context, owners []string) []SessionResult {
+	results := make([]SessionResult, len(owners))
+	for i, owner := range owners {
+		results[i].Owner = owner
+	}
+	otherLayout := LayoutHash
+	if sm.layout == LayoutHash {
+		otherLayout = LayoutBlob
+	}
+
+	sm.getSessionsLayout(ctx, results, sm.layout)
+	//Sessions not converted yet are loaded with the other layout
+	var fallback []SessionResult
+	var fallbackIndexes []int
+	for i, result := range results {
+		if (sm.layout == LayoutBlob && result.Err == redis.Nil) || isWrongType(result.Err) {
+			fallback = append(fallback, SessionResult{Owner: result.Owner})
+			fallbackIndexes = append(fallbackIndexes, i)
+		}
+	}
+	if len(fallback) > 0 {
+		sm.getSessionsLayout(ctx, fallback, otherLayout)
+		for i, result := range fallback {
+			if isWrongType(result.Err) {
+				result.Err = redis.Nil
+			}
+			results[fallbackIndexes[i]] = result
+		}
+	}
+
+	for i := range results {
+		if results[i].Err != nil {
+			sm.logLoadError(ctx, "GetSessions", results[i].Owner, results[i].Err)
+			continue
+		}
+		results[i].Session.codec = sm.codec
+	}
+	return results
+}
+
+// Loads sessions of results with a layout, a blob key of other type is reported as redis.Nil by MGET
+func (sm *RedisSessionManager) getSessionsLayout(ctx context.Context, results []SessionResult, layout StorageLayout) {
+	if layout == LayoutBlob {
+		keys := make([]string, len(results))
+		for i, result := range results {
+			keys[i] = sm.GetSessionKey(result.Owner)
+		}
+		values, errGet := sm.redisClient.MGet(ctx, keys...).Result()
+		for i := range results {
+			if errGet != nil {
+				results[i].Err = errGet
+				continue
+			}
+			if values[i] == nil {
+				results[i].Err = redis.Nil
+				continue
+			}
+			session := &APISession{}
+			errDecode := DecodeValue([]byte(values[i].(string)), session)
+			if errDecode != nil {
+				results[i].Err = &decodeError{errDecode}
+				continue
+			}
+			results[i].Session = session
+		}
+		return
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(results))
+	//Errors of commands are read from each command
+	sm.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, result := range results {
+			cmds[i] = pipe.HGetAll(ctx, sm.GetSessionKey(result.Owner))
+		}
+		return nil
+	})
+	for i, cmd := range cmds {
+		fields, errGet := cmd.Result()
+		if errGet != nil {
+			results[i].Err = errGet
+			continue
+		}
+		if len(fields) == 0 {
+			results[i].Err = redis.Nil
+			continue
+		}
+		session, errDecode := decodeSessionHash(fields)
+		if errDecode != nil {
+			results[i].Err = &decodeError{errDecode}
+			continue
+		}
+		results[i].Session = session
+	}
+}
+
+// SetSessions saves many sessions in one transaction, each session is saved to its owner like SetSession.
+// Online users and cache invalidation are updated in the same transaction, so readers never see
+// a session partially written. Sessions are saved independently: a failed save doesn't stop the others.
+//
+// Returns:
+//   - []SessionResult: result of each session, in order of sessions
+func (sm *RedisSessionManager) SetSessions(ctx context.Context, sessions []*APISession) []SessionResult {
+	results := make([]SessionResult, len(sessions))
+	ranges := make([]cmdRange, len(sessions))
+	onlineCmds := make([]*redis.IntCmd, len(sessions))
+	now := time.Now().UnixMilli()
+
+	//Hash layout writes a session with several commands
+	cmds, _ := sm.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, session := range sessions {
+			results[i].Owner = session.Owner
+			session.Updated = now
+			session.Version++
+			ranges[i].start = pipe.Len()
+			errQueue := sm.queueWriteSession(ctx, pipe, sm.GetSessionKey(session.Owner), session, sm.sessionTTL)
+			if errQueue != nil {
+				//Session failed to encode, nothing is queued
+				results[i].Err = errQueue
+				continue
+			}
+			if sm.trackOnlineUsers {
+				onlineCmds[i] = pipe.ZAdd(ctx, sm.onlineUserKey, redis.Z{
+					Score:  float64(session.Updated),
+					Member: session.Owner,
+				})
+			}
+			ranges[i].end = pipe.Len()
+			sm.queueInvalidateSession(ctx, pipe, session.Owner)
+		}
+		return nil
+	})
+
+	for i, session := range sessions {
+		if results[i].Err == nil {
+			results[i].Err = ranges[i].err(cmds)
+		}
+		if results[i].Err != nil {
+			session.Version--
+			sm.logRedisError(ctx, "SetSessions", session.Owner, results[i].Err)
+			continue
+		}
+		results[i].Session = session
+		if onlineCmds[i] != nil && onlineCmds[i].Val() > 0 {
+			sm.emitPresence(ctx, session.Owner, PresenceOnline, session.Updated)
+		}
+	}
+	return results
+}
+
+// DeleteSessions deletes sessions of many owners in one pipeline like DeleteSession.
+// Online users and cache invalidation are updated in the same pipeline.
+//
+// Returns:
+//   - []SessionResult: result of each owner, in order of owners. Deleting a missing session is not an error.
+func (sm *RedisSessionManager) DeleteSessions(ctx context.Context, owners []string) []SessionResult {
+	results := make([]SessionResult, len(owners))
+	ranges := make([]cmdRange, len(owners))
+	offlineCmds := make([]*redis.IntCmd, len(owners))
+
+	cmds, _ := sm.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, owner := range owners {
+			results[i].Owner = owner
+			ranges[i].start = pipe.Len()
+			pipe.Del(ctx, sm.GetSessionKey(owner))
+			if sm.trackOnlineUsers {
+				offlineCmds[i] = pipe.ZRem(ctx, sm.onlineUserKey, owner)
+			}
+			ranges[i].end = pipe.Len()
+			sm.queueInvalidateSession(ctx, pipe, owner)
+		}
+		return nil
+	})
+
+	now := time.Now().UnixMilli()
+	for i, owner := range owners {
+		results[i].Err = ranges[i].err(cmds)
+		if results[i].Err != nil {
+			sm.logRedisError(ctx, "DeleteSessions", owner, results[i].Err)
+			continue
+		}
+		if offlineCmds[i] != nil && offlineCmds[i].Val() > 0 {
+			sm.emitPresence(ctx, owner, PresenceOffline, now)
+		}
+		sm.notifyDelete(ctx, owner)
+	}
+	return results
+}
+
+// Commands of one owner in a pipeline, from start to before end
+type cmdRange struct {
+	start int
+	end   int
+}
+
+// Returns first error of commands in range
+func (r cmdRange) err(cmds []redis.Cmder) error {
+	for i := r.start; i < r.end && i < len(cmds); i++ {
+		if cmds[i].Err() != nil {
+			return cmds[i].Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package apisession
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestBatch_SetGetDeleteSessions_Correct$ github.com/zeroboo/go-api-session -v
+func TestBatch_SetGetDeleteSessions_Correct(t *testing.T) {
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, true)
+	var owners []string
+	var sessions []*APISession
+	for i := 0; i < 3; i++ {
+		owner := fmt.Sprintf("user_%s_%d", t.Name(), i)
+		owners = append(owners, owner)
+		sessions = append(sessions, NewAPISessionWithPayload(owner, map[string]any{"index": i}))
+	}
+	sessionOwners = append(sessionOwners, owners...)
+	missingOwner := "user_" + t.Name() + "_missing"
+
+	setResults := manager.SetSessions(context.TODO(), sessions)
+	for i, result := range setResults {
+		assert.Equal(t, owners[i], result.Owner, "Results in order")
+		assert.Nil(t, result.Err, "Set session, no error")
+		assert.Equal(t, int64(1), result.Session.Version, "Version increased")
+	}
+	onlineUsers, _ := manager.GetOnlineUsers(context.TODO())
+	for _, owner := range owners {
+		assert.Contains(t, onlineUsers, owner, "Online users updated")
+	}
+
+	getResults := manager.GetSessions(context.TODO(), append(owners, missingOwner))
+	assert.Equal(t, 4, len(getResults), "Result of each owner")
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, getResults[i].Err, "Get session, no error")
+		assert.Equal(t, sessions[i].Id, getResults[i].Session.Id, "Session loaded")
+		assert.Equal(t, i, getResults[i].Session.GetPayloadInt("index"), "Payload loaded")
+	}
+	assert.Equal(t, redis.Nil, getResults[3].Err, "Missing session")
+	assert.Nil(t, getResults[3].Session, "No missing session")
+
+	deleteResults := manager.DeleteSessions(context.TODO(), owners[:2])
+	for _, result := range deleteResults {
+		assert.Nil(t, result.Err, "Delete session, no error")
+	}
+	getResults = manager.GetSessions(context.TODO(), owners)
+	assert.Equal(t, redis.Nil, getResults[0].Err, "Deleted")
+	assert.Equal(t, redis.Nil, getResults[1].Err, "Deleted")
+	assert.Nil(t, getResults[2].Err, "Not deleted")
+	onlineUsers, _ = manager.GetOnlineUsers(context.TODO())
+	assert.NotContains(t, onlineUsers, owners[0], "Removed from online users")
+	assert.Contains(t, onlineUsers, owners[2], "Kept in online users")
+}
+
+// go test -timeout 30s -run ^TestBatch_GetSessions_MixedLayouts$ github.com/zeroboo/go-api-session -v
+func TestBatch_GetSessions_MixedLayouts(t *testing.T) {
+	blobOwner := "user_" + t.Name() + "_blob"
+	hashOwner := "user_" + t.Name() + "_hash"
+	blobManager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	hashManager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	hashManager.SetStorageLayout(LayoutHash)
+	blobManager.StartSession(context.TODO(), blobOwner)
+	hashManager.StartSession(context.TODO(), hashOwner)
+	sessionOwners = append(sessionOwners, blobOwner, hashOwner)
+
+	for _, manager := range []*RedisSessionManager{blobManager, hashManager} {
+		results := manager.GetSessions(context.TODO(), []string{blobOwner, hashOwner})
+		assert.Nil(t, results[0].Err, "Blob session loaded")
+		assert.Equal(t, blobOwner, results[0].Session.Owner, "Blob session loaded")
+		assert.Nil(t, results[1].Err, "Hash session loaded")
+		assert.Equal(t, hashOwner, results[1].Session.Owner, "Hash session loaded")
+	}
+}
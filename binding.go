@@ -0,0 +1,150 @@
+package apisession
+
+import (
+	"context"
+	"net"
+)
+
+// ClientInfo is fingerprint of a client, empty attributes are unknown
+type ClientInfo struct {
+	IP string `json:"ip,omitempty" msgpack:"ip,omitempty"`
+	//Network of IP: /24 for IPv4, /64 for IPv6, so clients moving inside a network still match
+	IPPrefix string `json:"pf,omitempty" msgpack:"pf,omitempty"`
+	//Hash of user agent, see Hash
+	UserAgentHash string `json:"ua,omitempty" msgpack:"ua,omitempty"`
+	DeviceId      string `json:"dv,omitempty" msgpack:"dv,omitempty"`
+}
+
+// Creates client info, IP prefix and user agent hash are computed. Empty values are unknown.
+func NewClientInfo(ip string, userAgent string, deviceId string) *ClientInfo {
+	client := &ClientInfo{
+		IP:       ip,
+		IPPrefix: GetIPPrefix(ip),
+		DeviceId: deviceId,
+	}
+	if userAgent != "" {
+		client.UserAgentHash = Hash(userAgent)
+	}
+	return client
+}
+
+// GetIPPrefix returns network of ip: /24 for IPv4, /64 for IPv6, empty if ip is invalid
+func GetIPPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if ipv4 := parsed.To4(); ipv4 != nil {
+		return (&net.IPNet{IP: ipv4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// Attributes of ClientInfo, reported in mismatches
+const (
+	ClientAttributeIP        = "ip"
+	ClientAttributeIPPrefix  = "ip_prefix"
+	ClientAttributeUserAgent = "user_agent"
+	ClientAttributeDeviceId  = "device_id"
+)
+
+// BindingAction is what happens to a call when a client attribute doesn't match the session
+type BindingAction int
+
+const (
+	//Attribute is not checked
+	BindingIgnore BindingAction = iota
+	//Call is allowed, mismatch is logged and reported to BindingPolicy.OnFlag
+	BindingFlag
+	//Call is rejected with ErrReauthRequired and RecordAPICall deletes the session
+	BindingReauth
+	//Call is rejected with ErrClientMismatch, session is kept
+	BindingReject
+)
+
+// BindingPolicy sets action of each client attribute mismatch, the most severe action is taken.
+//
+// An attribute mismatches if it is known by the session and differs in the request,
+// a request without the attribute mismatches too.
+type BindingPolicy struct {
+	IP        BindingAction
+	IPPrefix  BindingAction
+	UserAgent BindingAction
+	DeviceId  BindingAction
+
+	//Called on flagged calls, synchronously so it must not block. Can be nil.
+	//It may be called more than once for a call retried on concurrent updates.
+	OnFlag func(request *APIRequest, session *APISession, mismatches []string)
+}
+
+// SetBindingPolicy makes ValidateAPICall check client of requests against client of sessions.
+// Use RecordAPIRequest to pass client of requests, calls without client are not checked.
+// Should be called before the manager is in use.
+func (sm *RedisSessionManager) SetBindingPolicy(policy BindingPolicy) {
+	sm.bindingPolicy = &policy
+}
+
+// StartSessionWithClient creates a new session bound to a client and insert to db
+//
+// Returns:
+//   - session *APISession: new session with payload
+//   - error: error if exists, nil is successful
+func (sm *RedisSessionManager) StartSessionWithClient(ctx context.Context, owner string, client *ClientInfo, payload map[string]any) (*APISession, error) {
+	session := NewAPISessionWithPayload(owner, payload)
+	session.Client = client
+	errStart := sm.startSession(ctx, owner, session)
+	if errStart != nil {
+		return nil, errStart
+	}
+	return session, nil
+}
+
+// Checks client of request against session by binding policy.
+// Returns ErrReauthRequired or ErrClientMismatch if call is rejected.
+func (sm *RedisSessionManager) validateClient(request *APIRequest, session *APISession) error {
+	policy := sm.bindingPolicy
+	//Calls without client, like RecordAPICall, can't be checked
+	if policy == nil || session.Client == nil || request.Client == nil {
+		return nil
+	}
+	requestClient := request.Client
+
+	action := BindingIgnore
+	var mismatches []string
+	for _, check := range []struct {
+		attribute string
+		action    BindingAction
+		expected  string
+		actual    string
+	}{
+		{ClientAttributeIP, policy.IP, session.Client.IP, requestClient.IP},
+		{ClientAttributeIPPrefix, policy.IPPrefix, session.Client.IPPrefix, requestClient.IPPrefix},
+		{ClientAttributeUserAgent, policy.UserAgent, session.Client.UserAgentHash, requestClient.UserAgentHash},
+		{ClientAttributeDeviceId, policy.DeviceId, session.Client.DeviceId, requestClient.DeviceId},
+	} {
+		if check.action == BindingIgnore || check.expected == "" || check.expected == check.actual {
+			continue
+		}
+		mismatches = append(mismatches, check.attribute)
+		action = max(action, check.action)
+	}
+
+	switch action {
+	case BindingFlag:
+		sm.logger.Warn("client mismatch", "owner", request.Owner, "mismatches", mismatches)
+		if policy.OnFlag != nil {
+			policy.OnFlag(request, session, mismatches)
+		}
+	case BindingReauth:
+		return ErrReauthRequired
+	case BindingReject:
+		return ErrClientMismatch
+	}
+	return nil
+}
+
+// Deletes session of a call requiring re-authentication, so its id stops working for every client
+func (sm *RedisSessionManager) revokeForReauth(ctx context.Context, owner string) {
+	sm.logger.WarnContext(ctx, "session revoked for re-authentication", "owner", owner)
+	sm.DeleteSession(ctx, owner)
+}
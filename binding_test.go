@@ -0,0 +1,80 @@
+package apisession
+
+import (
+	"context"
+	"testing"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestBinding_Policy_Actions$ github.com/zeroboo/go-api-session -v
+func TestBinding_Policy_Actions(t *testing.T) {
+	for _, layout := range []StorageLayout{LayoutBlob, LayoutHash} {
+		owner := "user_" + t.Name()
+		var flagged []string
+		manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+		manager.SetStorageLayout(layout)
+		manager.SetBindingPolicy(BindingPolicy{
+			IP:        BindingFlag,
+			IPPrefix:  BindingReject,
+			UserAgent: BindingReject,
+			DeviceId:  BindingReauth,
+			OnFlag: func(request *APIRequest, session *APISession, mismatches []string) {
+				flagged = append(flagged, mismatches...)
+			},
+		})
+		client := NewClientInfo("10.0.0.1", "Mozilla/5.0", "device1")
+		session, errStart := manager.StartSessionWithClient(context.TODO(), owner, client, nil)
+		assert.Nil(t, errStart, "Start session, no error: layout %v", layout)
+		sessionOwners = append(sessionOwners, owner)
+		record := func(client *ClientInfo) error {
+			_, errRecord := manager.RecordAPIRequest(context.TODO(), &APIRequest{
+				Owner:     owner,
+				SessionId: session.Id,
+				URL:       "url1",
+				Client:    client,
+			})
+			return errRecord
+		}
+
+		assert.Nil(t, record(client), "Same client: layout %v", layout)
+		assert.Nil(t, record(NewClientInfo("10.0.0.2", "Mozilla/5.0", "device1")), "Same network: layout %v", layout)
+		assert.Equal(t, []string{ClientAttributeIP}, flagged, "IP change flagged: layout %v", layout)
+		assert.Equal(t, ErrClientMismatch, record(NewClientInfo("10.1.0.1", "Mozilla/5.0", "device1")), "Other network: layout %v", layout)
+		assert.Equal(t, ErrClientMismatch, record(NewClientInfo("10.0.0.1", "curl/8.0", "device1")), "Other user agent: layout %v", layout)
+		assert.Nil(t, record(nil), "Unknown client not checked: layout %v", layout)
+
+		assert.Equal(t, ErrReauthRequired, record(NewClientInfo("10.0.0.1", "Mozilla/5.0", "device2")), "Other device: layout %v", layout)
+		_, errGet := manager.GetSession(context.TODO(), owner)
+		assert.Equal(t, redis.Nil, errGet, "Session deleted for re-authentication: layout %v", layout)
+	}
+}
+
+// go test -timeout 30s -run ^TestBinding_RecordAPICall_NotChecked$ github.com/zeroboo/go-api-session -v
+func TestBinding_RecordAPICall_NotChecked(t *testing.T) {
+	for _, layout := range []StorageLayout{LayoutBlob, LayoutHash} {
+		owner := "user_" + t.Name()
+		manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+		manager.SetStorageLayout(layout)
+		manager.SetBindingPolicy(BindingPolicy{
+			IP:       BindingReject,
+			DeviceId: BindingReauth,
+		})
+		session, errStart := manager.StartSessionWithClient(context.TODO(), owner, NewClientInfo("10.0.0.1", "Mozilla/5.0", "device1"), nil)
+		assert.Nil(t, errStart, "Start session, no error: layout %v", layout)
+		sessionOwners = append(sessionOwners, owner)
+
+		_, errRecord := manager.RecordAPICall(context.TODO(), session.Id, owner, "url1")
+		assert.Nil(t, errRecord, "Call without client allowed: layout %v", layout)
+		_, errGet := manager.GetSession(context.TODO(), owner)
+		assert.Nil(t, errGet, "Session kept: layout %v", layout)
+	}
+}
+
+// go test -timeout 30s -run ^TestBinding_GetIPPrefix$ github.com/zeroboo/go-api-session -v
+func TestBinding_GetIPPrefix(t *testing.T) {
+	assert.Equal(t, "192.168.1.0/24", GetIPPrefix("192.168.1.20"), "IPv4")
+	assert.Equal(t, "2001:db8:1:2::/64", GetIPPrefix("2001:db8:1:2:3:4:5:6"), "IPv6")
+	assert.Equal(t, "", GetIPPrefix("invalid"), "Invalid ip")
+}
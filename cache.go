@@ -0,0 +1,237 @@
+package apisession
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Bounded LRU cache of sessions with expiration
+type sessionCache struct {
+	mu      sync.Mutex
+	maxSize int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List //Front is most recently used
+
+	//Invalidation generations of owners being loaded, see beginLoad
+	loads map[string]*sessionCacheLoad
+}
+
+type sessionCacheLoad struct {
+	generation uint64
+	pending    int
+}
+
+type sessionCacheEntry struct {
+	owner   string
+	session *APISession
+	expires time.Time
+}
+
+func newSessionCache(maxSize int, ttl time.Duration) *sessionCache {
+	return &sessionCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		loads:   make(map[string]*sessionCacheLoad),
+	}
+}
+
+// Starts loading session of owner from redis, returns invalidation generation of owner to pass to finishLoad
+func (c *sessionCache) beginLoad(owner string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	load, exist := c.loads[owner]
+	if !exist {
+		load = &sessionCacheLoad{}
+		c.loads[owner] = load
+	}
+	load.pending++
+	return load.generation
+}
+
+// Caches a session loaded since beginLoad, unless owner was invalidated meanwhile: it may be stale then.
+// Session is nil if loading failed.
+func (c *sessionCache) finishLoad(owner string, generation uint64, session *APISession, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	load := c.loads[owner]
+	load.pending--
+	if load.pending == 0 {
+		delete(c.loads, owner)
+	}
+	if session != nil && load.generation == generation {
+		c.putLocked(owner, session, now)
+	}
+}
+
+func (c *sessionCache) get(owner string, now time.Time) *APISession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, exist := c.entries[owner]
+	if !exist {
+		return nil
+	}
+	entry := element.Value.(*sessionCacheEntry)
+	if now.After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, owner)
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return entry.session
+}
+
+func (c *sessionCache) put(owner string, session *APISession, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.putLocked(owner, session, now)
+}
+
+// Caches session of owner, c.mu must be held
+func (c *sessionCache) putLocked(owner string, session *APISession, now time.Time) {
+	if element, exist := c.entries[owner]; exist {
+		c.order.Remove(element)
+	}
+	c.entries[owner] = c.order.PushFront(&sessionCacheEntry{
+		owner:   owner,
+		session: session,
+		expires: now.Add(c.ttl),
+	})
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*sessionCacheEntry).owner)
+	}
+}
+
+func (c *sessionCache) remove(owner string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if load, exist := c.loads[owner]; exist {
+		load.generation++
+	}
+	if element, exist := c.entries[owner]; exist {
+		c.order.Remove(element)
+		delete(c.entries, owner)
+	}
+}
+
+func (c *sessionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	for _, load := range c.loads {
+		load.generation++
+	}
+}
+
+// EnableSessionCache keeps recently loaded sessions in memory for GetSessionCached.
+// Cache invalidation is enabled too, see EnableCacheInvalidation.
+// Should be called before the manager is in use.
+//
+// Params:
+//   - maxSize: max cached sessions, least recently used ones are dropped
+//   - ttl: max time a session is cached
+func (sm *RedisSessionManager) EnableSessionCache(maxSize int, ttl time.Duration) {
+	sm.cache = newSessionCache(maxSize, ttl)
+	sm.cacheInvalidation.Store(true)
+}
+
+// EnableCacheInvalidation publishes owners of sessions changed by this manager, so instances with
+// session cache drop them. Every instance writing sessions read by cached instances must enable it,
+// including admin tools without a cache of their own.
+func (sm *RedisSessionManager) EnableCacheInvalidation() {
+	sm.cacheInvalidation.Store(true)
+}
+
+// GetCacheInvalidationChannel returns redis pub/sub channel where owners of changed sessions are published
+func (sm *RedisSessionManager) GetCacheInvalidationChannel() string {
+	return fmt.Sprintf("cache:%s", sm.sessionKeyPrefix)
+}
+
+// GetSessionCached loads session from cache, or from redis if not cached.
+// Falls back to GetSession if cache is not enabled.
+//
+// Cached sessions are only for read only lookups like payload and session id validation:
+// they must not be modified, and their api call counters are not refreshed by RecordAPICall.
+// Other changes are invalidated on this instance, and on other instances running RunCacheInvalidation.
+func (sm *RedisSessionManager) GetSessionCached(ctx context.Context, owner string) (*APISession, error) {
+	if sm.cache == nil {
+		return sm.GetSession(ctx, owner)
+	}
+	now := time.Now()
+	session := sm.cache.get(owner, now)
+	if session != nil {
+		return session, nil
+	}
+	generation := sm.cache.beginLoad(owner)
+	session, errGet := sm.GetSession(ctx, owner)
+	sm.cache.finishLoad(owner, generation, session, now)
+	if errGet != nil {
+		return nil, errGet
+	}
+	return session, nil
+}
+
+// Drops cached session of owner here and publishes the change to other instances, if cache invalidation is enabled
+func (sm *RedisSessionManager) invalidateSession(ctx context.Context, owner string) {
+	if sm.cache != nil {
+		sm.cache.remove(owner)
+	}
+	if !sm.cacheInvalidation.Load() {
+		return
+	}
+	cmd := sm.redisClient.Publish(ctx, sm.GetCacheInvalidationChannel(), owner)
+	if cmd.Err() != nil {
+		sm.logRedisError(ctx, "InvalidateSession", owner, cmd.Err())
+	}
+}
+
+// Drops cached session of owner here and queues publishing the change to other instances, if cache invalidation is enabled
+func (sm *RedisSessionManager) queueInvalidateSession(ctx context.Context, pipe redis.Pipeliner, owner string) {
+	if sm.cache != nil {
+		sm.cache.remove(owner)
+	}
+	if !sm.cacheInvalidation.Load() {
+		return
+	}
+	pipe.Publish(ctx, sm.GetCacheInvalidationChannel(), owner)
+}
+
+// RunCacheInvalidation drops cached sessions changed by other instances until ctx is done.
+// Cache is cleared when subscription is lost since changes may be missed.
+func (sm *RedisSessionManager) RunCacheInvalidation(ctx context.Context) error {
+	if sm.cache == nil {
+		return fmt.Errorf("session cache is disabled")
+	}
+	pubsub := sm.redisClient.Subscribe(ctx, sm.GetCacheInvalidationChannel())
+	defer pubsub.Close()
+	_, errReceive := pubsub.Receive(ctx)
+	if errReceive != nil {
+		return errReceive
+	}
+	//Sessions cached before subscribing may have missed changes
+	sm.cache.clear()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case message, ok := <-messages:
+			if !ok {
+				sm.cache.clear()
+				return nil
+			}
+			sm.cache.remove(message.Payload)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package apisession
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestSessionCache_InvalidatedByOtherInstance$ github.com/zeroboo/go-api-session -v
+func TestSessionCache_InvalidatedByOtherInstance(t *testing.T) {
+	owner := "user_" + t.Name()
+	//Own prefix, so invalidation channel has no subscribers of other runs
+	prefix := fmt.Sprintf("%s_%d", sessionPrefix, time.Now().UnixNano())
+	reader := NewRedisSessionManager(redisClient, prefix, 10000, 10000, 10, 0, false)
+	reader.EnableSessionCache(100, time.Minute)
+	writer := NewRedisSessionManager(redisClient, prefix, 10000, 10000, 10, 0, false)
+	//No cache of its own, publishes changes only
+	writer.EnableCacheInvalidation()
+	defer writer.DeleteSession(context.TODO(), owner)
+	//Started before subscribing, so its invalidation doesn't evict the session cached below
+	writer.StartSessionWithPayload(context.TODO(), owner, map[string]any{"nickname": "Jaian"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//Cache is cleared when subscribed, so wait for a marker entry to be dropped
+	reader.cache.put("marker", NewAPISession("marker"), time.Now())
+	go reader.RunCacheInvalidation(ctx)
+	assert.Eventually(t, func() bool {
+		return reader.cache.get("marker", time.Now()) == nil
+	}, time.Second, 5*time.Millisecond, "Subscribed to invalidation")
+
+	cached, errGet := reader.GetSessionCached(context.TODO(), owner)
+	assert.Nil(t, errGet, "Get cached session, no error")
+	assert.Equal(t, "Jaian", cached.GetPayloadString("nickname"), "Loaded from redis")
+
+	//Change redis behind the manager: cache still serves old session
+	redisClient.Del(context.TODO(), reader.GetSessionKey(owner))
+	cached, errGet = reader.GetSessionCached(context.TODO(), owner)
+	assert.Nil(t, errGet, "Served from cache, no error")
+	assert.Equal(t, "Jaian", cached.GetPayloadString("nickname"), "Served from cache")
+
+	writer.StartSessionWithPayload(context.TODO(), owner, map[string]any{"nickname": "Nobita"})
+	assert.Eventually(t, func() bool {
+		cached, errGet = reader.GetSessionCached(context.TODO(), owner)
+		return errGet == nil && cached.GetPayloadString("nickname") == "Nobita"
+	}, time.Second, 10*time.Millisecond, "Invalidated by other instance")
+}
+
+// go test -timeout 30s -run ^TestSessionCache_BoundedAndExpired$ github.com/zeroboo/go-api-session -v
+func TestSessionCache_BoundedAndExpired(t *testing.T) {
+	cache := newSessionCache(2, time.Second)
+	now := time.Now()
+	cache.put("user1", NewAPISession("user1"), now)
+	cache.put("user2", NewAPISession("user2"), now)
+	cache.get("user1", now)
+	cache.put("user3", NewAPISession("user3"), now)
+
+	assert.NotNil(t, cache.get("user1", now), "Recently used kept")
+	assert.Nil(t, cache.get("user2", now), "Least recently used dropped")
+	assert.NotNil(t, cache.get("user3", now), "New entry kept")
+	assert.Nil(t, cache.get("user3", now.Add(2*time.Second)), "Expired entry dropped")
+}
+
+// go test -timeout 30s -run ^TestSessionCache_InvalidatedWhileLoading$ github.com/zeroboo/go-api-session -v
+func TestSessionCache_InvalidatedWhileLoading(t *testing.T) {
+	cache := newSessionCache(2, time.Second)
+	now := time.Now()
+
+	generation := cache.beginLoad("user1")
+	cache.remove("user1")
+	cache.finishLoad("user1", generation, NewAPISession("user1"), now)
+	assert.Nil(t, cache.get("user1", now), "Load started before invalidation not cached")
+
+	generation = cache.beginLoad("user1")
+	cache.finishLoad("user1", generation, NewAPISession("user1"), now)
+	assert.NotNil(t, cache.get("user1", now), "Load after invalidation cached")
+	assert.Empty(t, cache.loads, "No load pending")
+}
+
+// go test -race -timeout 30s -run ^TestSessionCache_ConcurrentLoadsAndInvalidations$ github.com/zeroboo/go-api-session -v
+func TestSessionCache_ConcurrentLoadsAndInvalidations(t *testing.T) {
+	cache := newSessionCache(10, time.Minute)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				generation := cache.beginLoad("user1")
+				cache.finishLoad("user1", generation, NewAPISession("user1"), time.Now())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				cache.remove("user1")
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Empty(t, cache.loads, "No load pending")
+}
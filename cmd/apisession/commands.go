@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	apisession "github.com/zeroboo/go-api-session"
+)
+
+type command struct {
+	conf    config
+	client  *redis.Client
+	manager *apisession.RedisSessionManager
+	out     io.Writer
+	errOut  io.Writer
+}
+
+// Session with its TTL, TTL is -1 if session doesn't expire
+type sessionView struct {
+	*apisession.APISession
+	TTL int64 `json:"ttl"`
+}
+
+func (cmd *command) newFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(cmd.errOut)
+	return flags
+}
+
+func (cmd *command) isJSON() bool {
+	return cmd.conf.output == "json"
+}
+
+func (cmd *command) writeJSON(v any) error {
+	encoder := json.NewEncoder(cmd.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (cmd *command) newTable(header ...string) *tabwriter.Writer {
+	table := tabwriter.NewWriter(cmd.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, strings.Join(header, "\t"))
+	return table
+}
+
+func formatMillis(millis int64) string {
+	if millis == 0 {
+		return "-"
+	}
+	return time.UnixMilli(millis).Format(time.RFC3339)
+}
+
+func formatTTL(ttl int64) string {
+	if ttl < 0 {
+		return "none"
+	}
+	return (time.Duration(ttl) * time.Millisecond).String()
+}
+
+// Returns TTL of the session key in milliseconds, -1 if session doesn't expire
+func (cmd *command) getTTL(ctx context.Context, owner string) (int64, error) {
+	ttl, errTTL := cmd.client.PTTL(ctx, cmd.manager.GetSessionKey(owner)).Result()
+	if errTTL != nil {
+		return 0, errTTL
+	}
+	if ttl < 0 {
+		return -1, nil
+	}
+	return ttl.Milliseconds(), nil
+}
+
+func requireOwners(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing owner")
+	}
+	return nil
+}
+
+func (cmd *command) list(ctx context.Context, args []string) error {
+	flags := cmd.newFlags("list")
+	ownerPattern := flags.String("owner", "", "glob pattern of owners")
+	limit := flags.Int("limit", 0, "max sessions listed, 0 means no limit")
+	errParse := flags.Parse(args)
+	if errParse != nil {
+		return errParse
+	}
+
+	var sessions []*apisession.APISession
+	iter := cmd.manager.ScanSessions(apisession.SessionFilter{OwnerPattern: *ownerPattern})
+	for iter.Next(ctx) {
+		sessions = append(sessions, iter.Session())
+		if *limit > 0 && len(sessions) >= *limit {
+			break
+		}
+	}
+	if iter.Err() != nil {
+		return iter.Err()
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Owner < sessions[j].Owner
+	})
+
+	if cmd.isJSON() {
+		if sessions == nil {
+			sessions = []*apisession.APISession{}
+		}
+		return cmd.writeJSON(sessions)
+	}
+	table := cmd.newTable("OWNER", "ID", "CREATED", "UPDATED", "URLS", "VERSION")
+	for _, session := range sessions {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%d\n", session.Owner, session.Id,
+			formatMillis(session.Created), formatMillis(session.Updated), len(session.Records), session.Version)
+	}
+	return table.Flush()
+}
+
+func (cmd *command) show(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("show needs one owner")
+	}
+	owner := args[0]
+	session, errGet := cmd.manager.GetSession(ctx, owner)
+	if errors.Is(errGet, redis.Nil) {
+		return fmt.Errorf("session of %s not found", owner)
+	}
+	if errGet != nil {
+		return errGet
+	}
+	ttl, errTTL := cmd.getTTL(ctx, owner)
+	if errTTL != nil {
+		return errTTL
+	}
+
+	if cmd.isJSON() {
+		return cmd.writeJSON(sessionView{APISession: session, TTL: ttl})
+	}
+	table := cmd.newTable("FIELD", "VALUE")
+	fmt.Fprintf(table, "Owner\t%s\n", session.Owner)
+	fmt.Fprintf(table, "Id\t%s\n", session.Id)
+	fmt.Fprintf(table, "Created\t%s\n", formatMillis(session.Created))
+	fmt.Fprintf(table, "Updated\t%s\n", formatMillis(session.Updated))
+	fmt.Fprintf(table, "Window\t%d\n", session.Window)
+	fmt.Fprintf(table, "Version\t%d\n", session.Version)
+	fmt.Fprintf(table, "TTL\t%s\n", formatTTL(ttl))
+	payloadKeys := make([]string, 0, len(session.Payload))
+	for key := range session.Payload {
+		payloadKeys = append(payloadKeys, key)
+	}
+	sort.Strings(payloadKeys)
+	for _, key := range payloadKeys {
+		fmt.Fprintf(table, "Payload.%s\t%v\n", key, session.Payload[key])
+	}
+	errFlush := table.Flush()
+	if errFlush != nil {
+		return errFlush
+	}
+
+	fmt.Fprintln(cmd.out)
+	urls := make([]string, 0, len(session.Records))
+	for url := range session.Records {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	table = cmd.newTable("URL", "COUNT", "LAST")
+	for _, url := range urls {
+		record := session.Records[url]
+		fmt.Fprintf(table, "%s\t%d\t%s\n", url, record.Count, formatMillis(record.Last))
+	}
+	return table.Flush()
+}
+
+// Writes result of each owner, returns error if any owner failed
+func (cmd *command) writeResults(action string, results []apisession.SessionResult) error {
+	type resultView struct {
+		Owner string `json:"owner"`
+		Error string `json:"error,omitempty"`
+	}
+	views := make([]resultView, len(results))
+	failed := 0
+	for i, result := range results {
+		views[i].Owner = result.Owner
+		if result.Err != nil {
+			views[i].Error = result.Err.Error()
+			failed++
+		}
+	}
+
+	var errWrite error
+	if cmd.isJSON() {
+		errWrite = cmd.writeJSON(views)
+	} else {
+		table := cmd.newTable("OWNER", "RESULT")
+		for _, view := range views {
+			result := action
+			if view.Error != "" {
+				result = "error: " + view.Error
+			}
+			fmt.Fprintf(table, "%s\t%s\n", view.Owner, result)
+		}
+		errWrite = table.Flush()
+	}
+	if errWrite != nil {
+		return errWrite
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d owners failed", failed, len(results))
+	}
+	return nil
+}
+
+func (cmd *command) delete(ctx context.Context, args []string) error {
+	errOwners := requireOwners(args)
+	if errOwners != nil {
+		return errOwners
+	}
+	return cmd.writeResults("deleted", cmd.manager.DeleteSessions(ctx, args))
+}
+
+// Rotates session ids: payload and counters are kept, but calls with the old id are rejected
+func (cmd *command) revoke(ctx context.Context, args []string) error {
+	errOwners := requireOwners(args)
+	if errOwners != nil {
+		return errOwners
+	}
+	results := make([]apisession.SessionResult, len(args))
+	for i, owner := range args {
+		results[i].Owner = owner
+		results[i].Session, results[i].Err = cmd.manager.UpdateSession(ctx, owner, func(session *apisession.APISession) error {
+			session.Id = apisession.GenerateSessionValue(owner)
+			return nil
+		})
+	}
+	return cmd.writeResults("revoked", results)
+}
+
+func (cmd *command) reset(ctx context.Context, args []string) error {
+	flags := cmd.newFlags("reset")
+	url := flags.String("url", "", "url to reset, all urls if empty")
+	errParse := flags.Parse(args)
+	if errParse != nil {
+		return errParse
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("reset needs one owner")
+	}
+	owner := flags.Arg(0)
+	errReset := cmd.manager.ResetCounters(ctx, owner, *url)
+	return cmd.writeResults("reset", []apisession.SessionResult{{Owner: owner, Err: errReset}})
+}
+
+func (cmd *command) grant(ctx context.Context, args []string) error {
+	flags := cmd.newFlags("grant")
+	url := flags.String("url", "", "url of the grant, all urls if empty")
+	calls := flags.Int64("calls", 0, "extra calls")
+	duration := flags.Duration("for", time.Hour, "time the grant can be used")
+	errParse := flags.Parse(args)
+	if errParse != nil {
+		return errParse
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("grant needs one owner")
+	}
+	owner := flags.Arg(0)
+	errGrant := cmd.manager.GrantQuota(ctx, owner, *url, *calls, *duration)
+	return cmd.writeResults("granted", []apisession.SessionResult{{Owner: owner, Err: errGrant}})
+}
+
+func (cmd *command) online(ctx context.Context, args []string) error {
+	onlineUsers, errOnline := cmd.manager.GetOnlineUsers(ctx)
+	if errOnline != nil {
+		return errOnline
+	}
+	if cmd.isJSON() {
+		return cmd.writeJSON(onlineUsers)
+	}
+	owners := make([]string, 0, len(onlineUsers))
+	for owner := range onlineUsers {
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		return onlineUsers[owners[i]] > onlineUsers[owners[j]]
+	})
+	table := cmd.newTable("OWNER", "LAST ACTIVE")
+	for _, owner := range owners {
+		fmt.Fprintf(table, "%s\t%s\n", owner, formatMillis(onlineUsers[owner]))
+	}
+	return table.Flush()
+}
+
+type statsView struct {
+	Sessions    int64 `json:"sessions"`
+	OnlineUsers int64 `json:"onlineUsers"`
+	//Urls tracked by sessions
+	Records int64 `json:"records"`
+	//Api calls in current window
+	Calls int64 `json:"calls"`
+	//Sessions reaching max calls of an url in current window, 0 if max calls is not set
+	Throttled int64 `json:"throttled"`
+}
+
+func (cmd *command) stats(ctx context.Context, args []string) error {
+	stats := statsView{}
+	window := time.Now().UnixMilli() / cmd.conf.window
+	iter := cmd.manager.ScanSessions(apisession.SessionFilter{})
+	for iter.Next(ctx) {
+		session := iter.Session()
+		stats.Sessions++
+		stats.Records += int64(len(session.Records))
+		if session.Window != window {
+			continue
+		}
+		throttled := false
+		for _, record := range session.Records {
+			stats.Calls += record.Count
+			if cmd.conf.maxCalls > 0 && record.Count >= cmd.conf.maxCalls {
+				throttled = true
+			}
+		}
+		if throttled {
+			stats.Throttled++
+		}
+	}
+	if iter.Err() != nil {
+		return iter.Err()
+	}
+	onlineUsers, errOnline := cmd.manager.GetOnlineUsers(ctx)
+	if errOnline != nil {
+		return errOnline
+	}
+	stats.OnlineUsers = int64(len(onlineUsers))
+
+	if cmd.isJSON() {
+		return cmd.writeJSON(stats)
+	}
+	table := cmd.newTable("STAT", "VALUE")
+	fmt.Fprintf(table, "Sessions\t%d\n", stats.Sessions)
+	fmt.Fprintf(table, "Online users\t%d\n", stats.OnlineUsers)
+	fmt.Fprintf(table, "Tracked urls\t%d\n", stats.Records)
+	fmt.Fprintf(table, "Calls in window\t%d\n", stats.Calls)
+	fmt.Fprintf(table, "Throttled sessions\t%d\n", stats.Throttled)
+	return table.Flush()
+}
@@ -0,0 +1,148 @@
+// Command apisession inspects and manages sessions stored in redis.
+//
+// Usage:
+//
+//	apisession [flags] <command> [command flags] [args]
+//
+// Commands:
+//
+//	list [-owner pattern] [-limit n]            list sessions
+//	show <owner>                                show a session with records, payload and TTL
+//	delete <owner>...                           delete sessions
+//	revoke <owner>...                           rotate session ids, clients must start a new session
+//	reset [-url url] <owner>                    reset api call counters of one or all urls
+//	grant [-url url] -calls n [-for d] <owner>  grant extra calls usable after max calls is reached
+//	online                                      list online users
+//	stats                                       count sessions, online users and api calls
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	redis "github.com/redis/go-redis/v9"
+	apisession "github.com/zeroboo/go-api-session"
+)
+
+func main() {
+	errRun := run(context.Background(), os.Args[1:], os.Stdout, os.Stderr)
+	if errRun != nil {
+		fmt.Fprintln(os.Stderr, "apisession:", errRun)
+		os.Exit(1)
+	}
+}
+
+// Config of the session manager, must match the config of applications using the sessions
+type config struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+	layout   string
+	codec    string
+	window   int64
+	maxCalls int64
+	output   string
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	conf := config{}
+	flags := flag.NewFlagSet("apisession", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&conf.addr, "addr", "localhost:6379", "redis address")
+	flags.StringVar(&conf.password, "password", "", "redis password")
+	flags.IntVar(&conf.db, "db", 0, "redis database")
+	flags.StringVar(&conf.prefix, "prefix", "session", "session key prefix")
+	flags.StringVar(&conf.layout, "layout", "blob", "storage layout of writes: blob or hash")
+	flags.StringVar(&conf.codec, "codec", "msgpack", "codec of writes: msgpack, json, cbor or protobuf")
+	flags.Int64Var(&conf.window, "window", 60000, "time window in milliseconds")
+	flags.Int64Var(&conf.maxCalls, "max-calls", 0, "max calls per window, shown in stats")
+	flags.StringVar(&conf.output, "output", "table", "output format: table or json")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: apisession [flags] <list|show|delete|revoke|reset|grant|online|stats> [args]")
+		flags.PrintDefaults()
+	}
+	errParse := flags.Parse(args)
+	if errParse != nil {
+		return errParse
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("missing command")
+	}
+	if conf.output != "table" && conf.output != "json" {
+		return fmt.Errorf("unknown output %q", conf.output)
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     conf.addr,
+		Password: conf.password,
+		DB:       conf.db,
+	})
+	defer client.Close()
+	manager, errManager := newManager(client, conf)
+	if errManager != nil {
+		return errManager
+	}
+
+	cmd := &command{
+		conf:    conf,
+		client:  client,
+		manager: manager,
+		out:     stdout,
+		errOut:  stderr,
+	}
+	name, commandArgs := flags.Arg(0), flags.Args()[1:]
+	switch name {
+	case "list":
+		return cmd.list(ctx, commandArgs)
+	case "show":
+		return cmd.show(ctx, commandArgs)
+	case "delete":
+		return cmd.delete(ctx, commandArgs)
+	case "revoke":
+		return cmd.revoke(ctx, commandArgs)
+	case "reset":
+		return cmd.reset(ctx, commandArgs)
+	case "grant":
+		return cmd.grant(ctx, commandArgs)
+	case "online":
+		return cmd.online(ctx, commandArgs)
+	case "stats":
+		return cmd.stats(ctx, commandArgs)
+	}
+	return fmt.Errorf("unknown command %q", name)
+}
+
+func newManager(client *redis.Client, conf config) (*apisession.RedisSessionManager, error) {
+	//Online users are tracked so deleted sessions are removed from online users.
+	//Session TTL is unused: commands only update or delete sessions, updates keep their TTL
+	manager := apisession.NewRedisSessionManager(client, conf.prefix, 0, conf.window, conf.maxCalls, 0, true)
+	//Applications caching sessions drop the ones changed here
+	manager.EnableCacheInvalidation()
+	switch strings.ToLower(conf.layout) {
+	case "blob":
+		manager.SetStorageLayout(apisession.LayoutBlob)
+	case "hash":
+		manager.SetStorageLayout(apisession.LayoutHash)
+	default:
+		return nil, fmt.Errorf("unknown layout %q", conf.layout)
+	}
+	switch strings.ToLower(conf.codec) {
+	case "msgpack":
+		manager.SetCodec(apisession.MsgpackCodec{})
+	case "json":
+		manager.SetCodec(apisession.JSONCodec{})
+	case "cbor":
+		manager.SetCodec(apisession.CBORCodec{})
+	case "protobuf":
+		manager.SetCodec(apisession.ProtobufCodec{})
+	default:
+		return nil, fmt.Errorf("unknown codec %q", conf.codec)
+	}
+	return manager, nil
+}
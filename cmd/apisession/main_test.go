@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	apisession "github.com/zeroboo/go-api-session"
+)
+
+const testPrefix = "clitest"
+
+func runCommand(t *testing.T, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	errRun := run(context.TODO(), append([]string{"-prefix", testPrefix}, args...), &stdout, &stderr)
+	return stdout.String(), errRun
+}
+
+// go test -timeout 30s -run ^TestCommands_ManageSession$ github.com/zeroboo/go-api-session/cmd/apisession -v
+func TestCommands_ManageSession(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer client.Close()
+	owner := "user_" + t.Name()
+	manager := apisession.NewRedisSessionManager(client, testPrefix, 10000, 60000, 10, 0, true)
+	session, _ := manager.StartSessionWithPayload(context.TODO(), owner, map[string]any{"nickname": "Jaian"})
+	defer manager.DeleteSession(context.TODO(), owner)
+	manager.RecordAPICall(context.TODO(), session.Id, owner, "url1")
+
+	output, errRun := runCommand(t, "-output", "json", "show", owner)
+	assert.Nil(t, errRun, "Show, no error")
+	var shown map[string]any
+	json.Unmarshal([]byte(output), &shown)
+	assert.Equal(t, session.Id, shown["i"], "Session id shown")
+	assert.Equal(t, "Jaian", shown["p"].(map[string]any)["nickname"], "Payload shown")
+	assert.Greater(t, shown["ttl"].(float64), float64(0), "TTL shown")
+
+	output, errRun = runCommand(t, "list", "-owner", "user_*")
+	assert.Nil(t, errRun, "List, no error")
+	assert.Contains(t, output, owner, "Session listed")
+
+	_, errRun = runCommand(t, "reset", owner)
+	assert.Nil(t, errRun, "Reset, no error")
+	loaded, _ := manager.GetSession(context.TODO(), owner)
+	assert.Equal(t, int64(0), loaded.GetCallRecord("url1").Count, "Counters reset")
+
+	_, errRun = runCommand(t, "revoke", owner)
+	assert.Nil(t, errRun, "Revoke, no error")
+	_, errRecord := manager.RecordAPICall(context.TODO(), session.Id, owner, "url1")
+	assert.Equal(t, apisession.ErrInvalidSession, errRecord, "Old session id rejected")
+
+	_, errRun = runCommand(t, "delete", owner)
+	assert.Nil(t, errRun, "Delete, no error")
+	_, errRun = runCommand(t, "show", owner)
+	assert.NotNil(t, errRun, "Deleted session not found")
+	onlineUsers, _ := manager.GetOnlineUsers(context.TODO())
+	assert.NotContains(t, onlineUsers, owner, "Removed from online users")
+
+	_, errRun = runCommand(t, "unknown")
+	assert.NotNil(t, errRun, "Unknown command")
+}
@@ -0,0 +1,120 @@
+package apisession
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Formats of stored values. Every encoded value starts with its format byte so a reader
+// can pick the matching codec, and values written before formats existed are read as msgpack.
+const (
+	FormatMsgpack  byte = 1
+	FormatJSON     byte = 2
+	FormatCBOR     byte = 3
+	FormatProtobuf byte = 4
+)
+
+var ErrUnknownFormat = fmt.Errorf("unknown session format")
+
+// Codec serializes sessions and other values stored by the manager
+type Codec interface {
+	//Format byte written before encoded values, must be unique among registered codecs.
+	//Values from 0x80 are reserved for legacy msgpack values without format byte.
+	Format() byte
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Format() byte                       { return FormatMsgpack }
+func (MsgpackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (MsgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+type JSONCodec struct{}
+
+func (JSONCodec) Format() byte                       { return FormatJSON }
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// Decodes maps in payload as map[string]any like other codecs
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+}.DecMode()
+
+// CBORCodec uses json tags of APISession
+type CBORCodec struct{}
+
+func (CBORCodec) Format() byte                       { return FormatCBOR }
+func (CBORCodec) Marshal(v any) ([]byte, error)      { return cbor.Marshal(v) }
+func (CBORCodec) Unmarshal(data []byte, v any) error { return cborDecMode.Unmarshal(data, v) }
+
+var codecsMu sync.RWMutex
+var codecs = map[byte]Codec{
+	FormatMsgpack:  MsgpackCodec{},
+	FormatJSON:     JSONCodec{},
+	FormatCBOR:     CBORCodec{},
+	FormatProtobuf: ProtobufCodec{},
+}
+
+// RegisterCodec makes values encoded by a custom codec readable by all managers
+func RegisterCodec(codec Codec) error {
+	if codec.Format() >= 0x80 {
+		return fmt.Errorf("format %d is reserved", codec.Format())
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.Format()] = codec
+	return nil
+}
+
+// Returns codec of a format, nil if not registered
+func GetCodec(format byte) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return codecs[format]
+}
+
+// EncodeValue encodes a value with codec, prefixed by codec's format byte
+func EncodeValue(codec Codec, v any) ([]byte, error) {
+	body, errMarshal := codec.Marshal(v)
+	if errMarshal != nil {
+		return nil, errMarshal
+	}
+	data := make([]byte, 0, len(body)+1)
+	data = append(data, codec.Format())
+	return append(data, body...), nil
+}
+
+// DecodeValue decodes a value encoded by EncodeValue with any registered codec.
+// Values without format byte are decoded as msgpack.
+func DecodeValue(data []byte, v any) error {
+	if len(data) == 0 {
+		return ErrUnknownFormat
+	}
+	format := data[0]
+	if format >= 0x80 {
+		return msgpack.Unmarshal(data, v)
+	}
+	codec := GetCodec(format)
+	if codec == nil {
+		return fmt.Errorf("%w: %d", ErrUnknownFormat, format)
+	}
+	return codec.Unmarshal(data[1:], v)
+}
+
+// SetCodec sets codec of new writes, default is MsgpackCodec.
+// Sessions written with other registered codecs are still readable, so codec can be
+// changed without breaking existing sessions.
+func (sm *RedisSessionManager) SetCodec(codec Codec) {
+	sm.codec = codec
+}
+
+func (sm *RedisSessionManager) GetCodec() Codec {
+	return sm.codec
+}
@@ -0,0 +1,372 @@
+package apisession
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ProtobufCodec encodes *APISession with this schema:
+//
+//	message APISession {
+//	  string id = 1;
+//	  string owner = 2;
+//	  map<string, APICallRecord> records = 3;
+//	  int64 window = 4;
+//	  google.protobuf.Struct payload = 5;
+//	  int64 created = 6;
+//	  int64 updated = 7;
+//	  bytes data = 8;
+//	  int64 version = 9;
+//	  map<string, QuotaGrant> grants = 10;
+//	  ClientInfo client = 11;
+//	}
+//	message APICallRecord {
+//	  int64 count = 1;
+//	  int64 last = 2;
+//	}
+//	message QuotaGrant {
+//	  int64 calls = 1;
+//	  int64 expires = 2;
+//	}
+//	message ClientInfo {
+//	  string ip = 1;
+//	  string ip_prefix = 2;
+//	  string user_agent_hash = 3;
+//	  string device_id = 4;
+//	}
+//
+// Payload values must be JSON encodable, numbers are decoded as float64.
+// Payload maps are encoded alone as google.protobuf.Struct, grant maps alone as
+// `message QuotaGrants { map<string, QuotaGrant> grants = 1; }`, client info alone as ClientInfo,
+// other values must be proto.Message.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Format() byte { return FormatProtobuf }
+
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	switch value := v.(type) {
+	case *APISession:
+		return marshalSessionProto(value)
+	case map[string]any:
+		return marshalPayloadProto(value)
+	case map[string]*QuotaGrant:
+		return appendGrantsProto(nil, protoGrantsGrants, value), nil
+	case *ClientInfo:
+		return marshalClientProto(value), nil
+	case proto.Message:
+		return proto.Marshal(value)
+	}
+	return nil, fmt.Errorf("protobuf codec doesn't support %T", v)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	switch value := v.(type) {
+	case *APISession:
+		return unmarshalSessionProto(data, value)
+	case *map[string]any:
+		payload, errPayload := unmarshalPayloadProto(data)
+		if errPayload != nil {
+			return errPayload
+		}
+		*value = payload
+		return nil
+	case *map[string]*QuotaGrant:
+		grants := make(map[string]*QuotaGrant)
+		errGrants := consumeProtoFields(data, func(num protowire.Number, varint uint64, bytes []byte) error {
+			if num != protoGrantsGrants {
+				return nil
+			}
+			return unmarshalGrantEntryProto(bytes, grants)
+		})
+		if errGrants != nil {
+			return errGrants
+		}
+		*value = grants
+		return nil
+	case *ClientInfo:
+		return unmarshalClientProto(data, value)
+	case proto.Message:
+		return proto.Unmarshal(data, value)
+	}
+	return fmt.Errorf("protobuf codec doesn't support %T", v)
+}
+
+const (
+	protoSessionId      protowire.Number = 1
+	protoSessionOwner   protowire.Number = 2
+	protoSessionRecords protowire.Number = 3
+	protoSessionWindow  protowire.Number = 4
+	protoSessionPayload protowire.Number = 5
+	protoSessionCreated protowire.Number = 6
+	protoSessionUpdated protowire.Number = 7
+	protoSessionData    protowire.Number = 8
+	protoSessionVersion protowire.Number = 9
+	protoSessionGrants  protowire.Number = 10
+	protoSessionClient  protowire.Number = 11
+
+	protoRecordCount protowire.Number = 1
+	protoRecordLast  protowire.Number = 2
+
+	protoGrantCalls   protowire.Number = 1
+	protoGrantExpires protowire.Number = 2
+
+	protoGrantsGrants protowire.Number = 1
+
+	protoClientIP            protowire.Number = 1
+	protoClientIPPrefix      protowire.Number = 2
+	protoClientUserAgentHash protowire.Number = 3
+	protoClientDeviceId      protowire.Number = 4
+
+	protoMapKey   protowire.Number = 1
+	protoMapValue protowire.Number = 2
+)
+
+func appendProtoString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendProtoInt64(b []byte, num protowire.Number, value int64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+func appendProtoBytes(b []byte, num protowire.Number, value []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+// Calls handle for each field, value is varint value or bytes content by wire type
+func consumeProtoFields(b []byte, handle func(num protowire.Number, varint uint64, bytes []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var varint uint64
+		var bytes []byte
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(b)
+		default:
+			//Unknown fields of other types are skipped
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		errHandle := handle(num, varint, bytes)
+		if errHandle != nil {
+			return errHandle
+		}
+	}
+	return nil
+}
+
+// Encodes payload as google.protobuf.Struct, values are converted through JSON
+func marshalPayloadProto(payload map[string]any) ([]byte, error) {
+	payloadJSON, errJSON := json.Marshal(payload)
+	if errJSON != nil {
+		return nil, errJSON
+	}
+	payloadStruct := &structpb.Struct{}
+	errStruct := protojson.Unmarshal(payloadJSON, payloadStruct)
+	if errStruct != nil {
+		return nil, errStruct
+	}
+	return proto.Marshal(payloadStruct)
+}
+
+func unmarshalPayloadProto(b []byte) (map[string]any, error) {
+	payloadStruct := &structpb.Struct{}
+	errPayload := proto.Unmarshal(b, payloadStruct)
+	if errPayload != nil {
+		return nil, errPayload
+	}
+	return payloadStruct.AsMap(), nil
+}
+
+func marshalSessionProto(session *APISession) ([]byte, error) {
+	var b []byte
+	b = appendProtoString(b, protoSessionId, session.Id)
+	b = appendProtoString(b, protoSessionOwner, session.Owner)
+	for url, record := range session.Records {
+		var recordBytes []byte
+		recordBytes = appendProtoInt64(recordBytes, protoRecordCount, record.Count)
+		recordBytes = appendProtoInt64(recordBytes, protoRecordLast, record.Last)
+
+		var entry []byte
+		entry = appendProtoString(entry, protoMapKey, url)
+		entry = appendProtoBytes(entry, protoMapValue, recordBytes)
+		b = appendProtoBytes(b, protoSessionRecords, entry)
+	}
+	b = appendProtoInt64(b, protoSessionWindow, session.Window)
+	if session.Payload != nil {
+		payloadBytes, errPayload := marshalPayloadProto(session.Payload)
+		if errPayload != nil {
+			return nil, errPayload
+		}
+		b = appendProtoBytes(b, protoSessionPayload, payloadBytes)
+	}
+	b = appendProtoInt64(b, protoSessionCreated, session.Created)
+	b = appendProtoInt64(b, protoSessionUpdated, session.Updated)
+	if len(session.Data) > 0 {
+		b = appendProtoBytes(b, protoSessionData, session.Data)
+	}
+	b = appendProtoInt64(b, protoSessionVersion, session.Version)
+	b = appendGrantsProto(b, protoSessionGrants, session.Grants)
+	if session.Client != nil {
+		b = appendProtoBytes(b, protoSessionClient, marshalClientProto(session.Client))
+	}
+	return b, nil
+}
+
+func unmarshalSessionProto(b []byte, session *APISession) error {
+	session.Records = make(map[string]*APICallRecord)
+	return consumeProtoFields(b, func(num protowire.Number, varint uint64, bytes []byte) error {
+		switch num {
+		case protoSessionId:
+			session.Id = string(bytes)
+		case protoSessionOwner:
+			session.Owner = string(bytes)
+		case protoSessionRecords:
+			return unmarshalRecordEntryProto(bytes, session.Records)
+		case protoSessionWindow:
+			session.Window = int64(varint)
+		case protoSessionPayload:
+			payload, errPayload := unmarshalPayloadProto(bytes)
+			if errPayload != nil {
+				return errPayload
+			}
+			session.Payload = payload
+		case protoSessionCreated:
+			session.Created = int64(varint)
+		case protoSessionUpdated:
+			session.Updated = int64(varint)
+		case protoSessionData:
+			session.Data = append([]byte(nil), bytes...)
+		case protoSessionVersion:
+			session.Version = int64(varint)
+		case protoSessionGrants:
+			if session.Grants == nil {
+				session.Grants = make(map[string]*QuotaGrant)
+			}
+			return unmarshalGrantEntryProto(bytes, session.Grants)
+		case protoSessionClient:
+			session.Client = &ClientInfo{}
+			return unmarshalClientProto(bytes, session.Client)
+		}
+		return nil
+	})
+}
+
+func unmarshalRecordEntryProto(b []byte, records map[string]*APICallRecord) error {
+	url := ""
+	record := NewAPICallRecord()
+	errEntry := consumeProtoFields(b, func(num protowire.Number, varint uint64, bytes []byte) error {
+		switch num {
+		case protoMapKey:
+			url = string(bytes)
+		case protoMapValue:
+			return consumeProtoFields(bytes, func(num protowire.Number, varint uint64, bytes []byte) error {
+				switch num {
+				case protoRecordCount:
+					record.Count = int64(varint)
+				case protoRecordLast:
+					record.Last = int64(varint)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if errEntry != nil {
+		return errEntry
+	}
+	records[url] = record
+	return nil
+}
+
+// Appends grants as map<string, QuotaGrant> field
+func appendGrantsProto(b []byte, num protowire.Number, grants map[string]*QuotaGrant) []byte {
+	for url, grant := range grants {
+		var grantBytes []byte
+		grantBytes = appendProtoInt64(grantBytes, protoGrantCalls, grant.Calls)
+		grantBytes = appendProtoInt64(grantBytes, protoGrantExpires, grant.Expires)
+
+		var entry []byte
+		entry = appendProtoString(entry, protoMapKey, url)
+		entry = appendProtoBytes(entry, protoMapValue, grantBytes)
+		b = appendProtoBytes(b, num, entry)
+	}
+	return b
+}
+
+func unmarshalGrantEntryProto(b []byte, grants map[string]*QuotaGrant) error {
+	url := ""
+	grant := &QuotaGrant{}
+	errEntry := consumeProtoFields(b, func(num protowire.Number, varint uint64, bytes []byte) error {
+		switch num {
+		case protoMapKey:
+			url = string(bytes)
+		case protoMapValue:
+			return consumeProtoFields(bytes, func(num protowire.Number, varint uint64, bytes []byte) error {
+				switch num {
+				case protoGrantCalls:
+					grant.Calls = int64(varint)
+				case protoGrantExpires:
+					grant.Expires = int64(varint)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if errEntry != nil {
+		return errEntry
+	}
+	grants[url] = grant
+	return nil
+}
+
+func marshalClientProto(client *ClientInfo) []byte {
+	var b []byte
+	b = appendProtoString(b, protoClientIP, client.IP)
+	b = appendProtoString(b, protoClientIPPrefix, client.IPPrefix)
+	b = appendProtoString(b, protoClientUserAgentHash, client.UserAgentHash)
+	b = appendProtoString(b, protoClientDeviceId, client.DeviceId)
+	return b
+}
+
+func unmarshalClientProto(b []byte, client *ClientInfo) error {
+	return consumeProtoFields(b, func(num protowire.Number, varint uint64, bytes []byte) error {
+		switch num {
+		case protoClientIP:
+			client.IP = string(bytes)
+		case protoClientIPPrefix:
+			client.IPPrefix = string(bytes)
+		case protoClientUserAgentHash:
+			client.UserAgentHash = string(bytes)
+		case protoClientDeviceId:
+			client.DeviceId = string(bytes)
+		}
+		return nil
+	})
+}
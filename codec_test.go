@@ -0,0 +1,76 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func newCodecTestSession() *APISession {
+	session := NewAPISessionWithPayload("user1", map[string]any{
+		"nickname": "Jaian",
+		"tokens":   []any{"token1", "token2"},
+	})
+	session.Window = 12
+	session.GetCallRecord("url1").Count = 3
+	session.GetCallRecord("url1").Last = 1000
+	session.AddGrant("url1", 100, 5000, 1000)
+	session.Client = NewClientInfo("10.0.0.1", "Mozilla/5.0", "device1")
+	return session
+}
+
+// go test -timeout 30s -run ^TestCodec_RoundTrip_AllCodecs$ github.com/zeroboo/go-api-session -v
+func TestCodec_RoundTrip_AllCodecs(t *testing.T) {
+	for _, codec := range []Codec{MsgpackCodec{}, JSONCodec{}, CBORCodec{}, ProtobufCodec{}} {
+		session := newCodecTestSession()
+		data, errEncode := EncodeValue(codec, session)
+		assert.Nil(t, errEncode, "Encode, no error: %T", codec)
+		assert.Equal(t, codec.Format(), data[0], "Format byte prefix: %T", codec)
+
+		decoded := &APISession{}
+		errDecode := DecodeValue(data, decoded)
+		assert.Nil(t, errDecode, "Decode, no error: %T", codec)
+		assert.Equal(t, session.Id, decoded.Id, "Id: %T", codec)
+		assert.Equal(t, session.Owner, decoded.Owner, "Owner: %T", codec)
+		assert.Equal(t, session.Window, decoded.Window, "Window: %T", codec)
+		assert.Equal(t, session.Created, decoded.Created, "Created: %T", codec)
+		assert.Equal(t, session.Records, decoded.Records, "Records: %T", codec)
+		assert.Equal(t, session.Grants, decoded.Grants, "Grants: %T", codec)
+		assert.Equal(t, session.Client, decoded.Client, "Client: %T", codec)
+		assert.Equal(t, "Jaian", decoded.GetPayloadString("nickname"), "Payload: %T", codec)
+	}
+}
+
+// go test -timeout 30s -run ^TestCodec_LegacyMsgpack_Decoded$ github.com/zeroboo/go-api-session -v
+func TestCodec_LegacyMsgpack_Decoded(t *testing.T) {
+	session := newCodecTestSession()
+	data, _ := msgpack.Marshal(session)
+
+	decoded := &APISession{}
+	errDecode := DecodeValue(data, decoded)
+	assert.Nil(t, errDecode, "Decode value without format byte, no error")
+	assert.Equal(t, session.Id, decoded.Id, "Id")
+
+	errUnknown := DecodeValue([]byte{0x7f, 1, 2}, decoded)
+	assert.True(t, errors.Is(errUnknown, ErrUnknownFormat), "Unknown format, error")
+}
+
+// go test -timeout 30s -run ^TestCodec_ChangeCodec_ExistingSessionReadable$ github.com/zeroboo/go-api-session -v
+func TestCodec_ChangeCodec_ExistingSessionReadable(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	sessionId, errStart := manager.StartSession(context.TODO(), owner)
+	assert.Nil(t, errStart, "Start session, no error")
+	sessionOwners = append(sessionOwners, owner)
+
+	manager.SetCodec(JSONCodec{})
+	session, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	assert.Nil(t, errRecord, "Record with new codec, no error")
+	assert.Equal(t, int64(1), session.GetCallRecord("url1").Count, "Counter updated")
+
+	stored, _ := redisClient.Get(context.TODO(), manager.GetSessionKey(owner)).Bytes()
+	assert.Equal(t, FormatJSON, stored[0], "Stored with new codec")
+}
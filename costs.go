@@ -0,0 +1,42 @@
+package apisession
+
+import "time"
+
+// Cost of calls to urls without a default cost
+const DefaultCallCost int64 = 1
+
+// SetURLCosts sets default cost of calls to urls, max calls per window becomes a budget used
+// by calls by their costs. Urls are matched after normalization, see SetURLNormalizer.
+// Calls to other urls cost DefaultCallCost, APIRequest.Cost overrides costs of urls.
+// Should be called before the manager is in use.
+func (sm *RedisSessionManager) SetURLCosts(costs map[string]int64) {
+	sm.urlCosts = costs
+}
+
+// Returns cost of a call of request to normalized url
+func (sm *RedisSessionManager) getCallCost(request *APIRequest, url string) int64 {
+	if request.Cost > 0 {
+		return request.Cost
+	}
+	if cost, exist := sm.urlCosts[url]; exist && cost > 0 {
+		return cost
+	}
+	return DefaultCallCost
+}
+
+// GetRemainingBudget returns budget left for calls of a session to url in the window of now,
+// including active quota grants usable by url
+func (sm *RedisSessionManager) GetRemainingBudget(session *APISession, url string, now time.Time) int64 {
+	url = sm.normalizeURL(url)
+	millis := now.UnixMilli()
+	remaining := sm.maxCallPerWindow
+	if record, exist := session.Records[url]; exist && session.Window == millis/sm.windowSize {
+		remaining = max(remaining-record.Count, 0)
+	}
+	for _, grantURL := range []string{url, AllURLs} {
+		if grant, exist := session.Grants[grantURL]; exist && grant.Expires > millis {
+			remaining += grant.Calls
+		}
+	}
+	return remaining
+}
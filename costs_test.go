@@ -0,0 +1,79 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestCosts_WeightedCallsUseBudget$ github.com/zeroboo/go-api-session -v
+func TestCosts_WeightedCallsUseBudget(t *testing.T) {
+	for _, layout := range []StorageLayout{LayoutBlob, LayoutHash} {
+		owner := fmt.Sprintf("user_%s_%d", t.Name(), layout)
+		manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 3600000, 100, 0, false)
+		manager.SetStorageLayout(layout)
+		manager.SetURLCosts(map[string]int64{"/export": 50})
+		sessionId, _ := manager.StartSession(context.TODO(), owner)
+		sessionOwners = append(sessionOwners, owner)
+
+		session, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "/export")
+		assert.Nil(t, errRecord, "Export within budget allowed")
+		assert.Equal(t, int64(50), session.GetCallRecord("/export").Count, "Cost of url used")
+		assert.Equal(t, int64(50), manager.GetRemainingBudget(session, "/export", time.Now()), "Remaining budget")
+
+		session, errRecord = manager.RecordAPIRequest(context.TODO(), &APIRequest{
+			Owner:     owner,
+			SessionId: sessionId,
+			URL:       "/export",
+			Cost:      40,
+		})
+		assert.Nil(t, errRecord, "Request cost overrides url cost")
+		assert.Equal(t, int64(10), manager.GetRemainingBudget(session, "/export", time.Now()), "Remaining budget")
+
+		_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "/export")
+		assert.True(t, errors.Is(errRecord, ErrTooMany), "Call over budget rejected")
+
+		session, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "/read")
+		assert.Nil(t, errRecord, "Other urls cost default")
+		assert.Equal(t, int64(1), session.GetCallRecord("/read").Count, "Default cost used")
+	}
+}
+
+// go test -timeout 30s -run ^TestCosts_GrantsAndScopes$ github.com/zeroboo/go-api-session -v
+func TestCosts_GrantsAndScopes(t *testing.T) {
+	owner := "user_" + t.Name()
+	prefix := fmt.Sprintf("%s_%d", sessionPrefix, time.Now().UnixNano())
+	manager := NewRedisSessionManager(redisClient, prefix, 10000, 3600000, 10, 0, false)
+	manager.SetURLCosts(map[string]int64{"/export": 10})
+	manager.SetScopeLimits(ScopePolicy{
+		Owner: &ScopeLimit{MaxCallPerWindow: 25},
+	})
+	defer manager.DeleteSession(context.TODO(), owner)
+	sessionId, _ := manager.StartSession(context.TODO(), owner)
+
+	_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "/export")
+	assert.Nil(t, errRecord, "Export within budget allowed")
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "/export")
+	assert.True(t, errors.Is(errRecord, ErrTooMany), "Export over budget rejected")
+
+	//Grant must cover whole cost
+	manager.GrantQuota(context.TODO(), owner, "/export", 5, time.Minute)
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "/export")
+	assert.True(t, errors.Is(errRecord, ErrTooMany), "Grant smaller than cost not used")
+	manager.GrantQuota(context.TODO(), owner, "/export", 15, time.Minute)
+	session, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "/export")
+	assert.Nil(t, errRecord, "Grant used by cost")
+	assert.Equal(t, int64(10), session.Grants["/export"].Calls, "Grant calls left")
+
+	//Owner scope has 5 left
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "/export")
+	var scopeError *ScopeError
+	assert.True(t, errors.As(errRecord, &scopeError), "Owner budget exhausted")
+	assert.Equal(t, ScopeOwner, scopeError.Scope, "Owner scope tripped")
+	calls, _ := manager.GetScopeCalls(context.TODO(), ScopeOwner, owner)
+	assert.Equal(t, int64(20), calls, "Owner scope counts costs")
+}
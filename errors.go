@@ -1,7 +1,67 @@
 package apisession
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	redis "github.com/redis/go-redis/v9"
+)
 
 var ErrTooFast = fmt.Errorf("request too fast")
 var ErrTooMany = fmt.Errorf("too many requests")
 var ErrInvalidSession = fmt.Errorf("invalid session")
+var ErrBanned = fmt.Errorf("owner is banned")
+var ErrLockedOut = fmt.Errorf("owner is locked out")
+var ErrClientMismatch = fmt.Errorf("client doesn't match session")
+var ErrReauthRequired = fmt.Errorf("re-authentication required")
+var ErrQuotaExceeded = fmt.Errorf("quota exceeded")
+var ErrConflict = fmt.Errorf("session was modified concurrently")
+var ErrTooManyRecords = fmt.Errorf("too many urls tracked in session")
+
+// ErrorKind returns a short low cardinality description of an error from session manager,
+// empty for nil. Used as metric label and span attribute by wrappers of the manager.
+func ErrorKind(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrLockedOut):
+		//Before violation causing the lockout
+		return "locked_out"
+	case errors.Is(err, ErrTooFast):
+		return "too_fast"
+	case errors.Is(err, ErrTooMany):
+		return "too_many"
+	case errors.Is(err, ErrInvalidSession):
+		return "invalid_session"
+	case errors.Is(err, ErrBanned):
+		return "banned"
+	case errors.Is(err, ErrClientMismatch):
+		return "client_mismatch"
+	case errors.Is(err, ErrReauthRequired):
+		return "reauth_required"
+	case errors.Is(err, ErrQuotaExceeded):
+		return "quota_exceeded"
+	case errors.Is(err, ErrTooManyRecords):
+		return "too_many_records"
+	case errors.Is(err, redis.Nil):
+		return "session_not_found"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+// IsRejection returns true if error is a rejection of an api call, not a failure of the manager
+func IsRejection(err error) bool {
+	return errors.Is(err, ErrTooFast) ||
+		errors.Is(err, ErrTooMany) ||
+		errors.Is(err, ErrInvalidSession) ||
+		errors.Is(err, ErrBanned) ||
+		errors.Is(err, ErrLockedOut) ||
+		errors.Is(err, ErrClientMismatch) ||
+		errors.Is(err, ErrReauthRequired) ||
+		errors.Is(err, ErrQuotaExceeded) ||
+		errors.Is(err, ErrTooManyRecords)
+}
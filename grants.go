@@ -0,0 +1,112 @@
+package apisession
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Url of grants usable by calls to any url
+const AllURLs = ""
+
+// QuotaGrant is extra calls usable after max calls per window is reached, until it expires
+type QuotaGrant struct {
+	//Extra calls left
+	Calls int64 `json:"c" msgpack:"c"`
+
+	//Expired time in milliseconds
+	Expires int64 `json:"e" msgpack:"e"`
+}
+
+// ResetCounters clears api call counters of an owner so throttled calls are allowed again.
+// Session TTL is kept.
+//
+// Params:
+//   - url: url to reset, AllURLs resets every url
+func (sm *RedisSessionManager) ResetCounters(ctx context.Context, owner string, url string) error {
+	if url != AllURLs {
+		url = sm.normalizeURL(url)
+	}
+	_, errUpdate := sm.UpdateSession(ctx, owner, func(session *APISession) error {
+		session.ResetCounters(url)
+		return nil
+	})
+	return errUpdate
+}
+
+// ResetCounters clears calls and last call time of url, AllURLs resets every url
+func (ses *APISession) ResetCounters(url string) {
+	for recordURL, record := range ses.Records {
+		if url == AllURLs || recordURL == url {
+			record.Count = 0
+			record.Last = 0
+		}
+	}
+}
+
+// GrantQuota gives an owner extra calls, used by ValidateAPICall when max calls per window is reached.
+// A grant of an url having an active grant adds calls to it, and its expiry is extended if later.
+// Session TTL is kept.
+//
+// Params:
+//   - url: url of the grant, AllURLs for calls to any url
+//   - calls: extra calls
+//   - duration: time the grant can be used
+func (sm *RedisSessionManager) GrantQuota(ctx context.Context, owner string, url string, calls int64, duration time.Duration) error {
+	if calls <= 0 || duration <= 0 {
+		return fmt.Errorf("grant needs positive calls and duration")
+	}
+	if url != AllURLs {
+		url = sm.normalizeURL(url)
+	}
+	_, errUpdate := sm.UpdateSession(ctx, owner, func(session *APISession) error {
+		now := time.Now()
+		session.AddGrant(url, calls, now.Add(duration).UnixMilli(), now.UnixMilli())
+		return nil
+	})
+	return errUpdate
+}
+
+// AddGrant adds extra calls for url until expires (milliseconds), expired grants are dropped
+func (ses *APISession) AddGrant(url string, calls int64, expires int64, now int64) {
+	ses.dropExpiredGrants(now)
+	if ses.Grants == nil {
+		ses.Grants = make(map[string]*QuotaGrant)
+	}
+	grant, exist := ses.Grants[url]
+	if !exist {
+		ses.Grants[url] = &QuotaGrant{Calls: calls, Expires: expires}
+		return
+	}
+	grant.Calls += calls
+	grant.Expires = max(grant.Expires, expires)
+}
+
+// Uses cost calls of an active grant of url, or of AllURLs.
+// Returns false if no grant has enough calls left.
+func (ses *APISession) useGrant(url string, now int64, cost int64) bool {
+	ses.dropExpiredGrants(now)
+	for _, grantURL := range []string{url, AllURLs} {
+		grant, exist := ses.Grants[grantURL]
+		if !exist || grant.Calls < cost {
+			continue
+		}
+		grant.Calls -= cost
+		if grant.Calls <= 0 {
+			delete(ses.Grants, grantURL)
+		}
+		return true
+	}
+	return false
+}
+
+func (ses *APISession) dropExpiredGrants(now int64) {
+	for url, grant := range ses.Grants {
+		if grant.Expires <= now || grant.Calls <= 0 {
+			delete(ses.Grants, url)
+		}
+	}
+	if len(ses.Grants) == 0 {
+		ses.Grants = nil
+	}
+}
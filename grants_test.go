@@ -0,0 +1,73 @@
+package apisession
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestResetCounters_ThrottledCallAllowed$ github.com/zeroboo/go-api-session -v
+func TestResetCounters_ThrottledCallAllowed(t *testing.T) {
+	for _, layout := range []StorageLayout{LayoutBlob, LayoutHash} {
+		owner := "user_" + t.Name()
+		manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 1, 0, false)
+		manager.SetStorageLayout(layout)
+		sessionId, _ := manager.StartSession(context.TODO(), owner)
+		sessionOwners = append(sessionOwners, owner)
+
+		manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+		manager.RecordAPICall(context.TODO(), sessionId, owner, "url2")
+		_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+		assert.Equal(t, ErrTooMany, errRecord, "Throttled: layout %v", layout)
+
+		errReset := manager.ResetCounters(context.TODO(), owner, "url1")
+		assert.Nil(t, errReset, "Reset url1, no error: layout %v", layout)
+		_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+		assert.Nil(t, errRecord, "Allowed after reset: layout %v", layout)
+		_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "url2")
+		assert.Equal(t, ErrTooMany, errRecord, "Other url not reset: layout %v", layout)
+
+		manager.ResetCounters(context.TODO(), owner, AllURLs)
+		_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "url2")
+		assert.Nil(t, errRecord, "Allowed after reset all: layout %v", layout)
+	}
+}
+
+// go test -timeout 30s -run ^TestGrantQuota_ExtraCallsUsed$ github.com/zeroboo/go-api-session -v
+func TestGrantQuota_ExtraCallsUsed(t *testing.T) {
+	for _, layout := range []StorageLayout{LayoutBlob, LayoutHash} {
+		owner := "user_" + t.Name()
+		manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 1, 0, false)
+		manager.SetStorageLayout(layout)
+		sessionId, _ := manager.StartSession(context.TODO(), owner)
+		sessionOwners = append(sessionOwners, owner)
+
+		errGrant := manager.GrantQuota(context.TODO(), owner, "url1", 2, time.Hour)
+		assert.Nil(t, errGrant, "Grant url1, no error: layout %v", layout)
+		manager.GrantQuota(context.TODO(), owner, AllURLs, 1, time.Hour)
+
+		for i := 0; i < 4; i++ {
+			_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+			assert.Nil(t, errRecord, "Call %d allowed by max calls and grants: layout %v", i, layout)
+		}
+		_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+		assert.Equal(t, ErrTooMany, errRecord, "Grants used up: layout %v", layout)
+
+		session, _ := manager.GetSession(context.TODO(), owner)
+		assert.Nil(t, session.Grants, "Used grants dropped: layout %v", layout)
+	}
+}
+
+// go test -timeout 30s -run ^TestGrantQuota_Expired$ github.com/zeroboo/go-api-session -v
+func TestGrantQuota_Expired(t *testing.T) {
+	session := NewAPISession("user1")
+	session.AddGrant(AllURLs, 10, 2000, 1000)
+	session.AddGrant(AllURLs, 5, 1500, 1000)
+	assert.Equal(t, &QuotaGrant{Calls: 15, Expires: 2000}, session.Grants[AllURLs], "Grants merged")
+
+	assert.True(t, session.useGrant("url1", 1999, 1), "Active grant used")
+	assert.False(t, session.useGrant("url1", 2000, 1), "Expired grant not used")
+	assert.Nil(t, session.Grants, "Expired grant dropped")
+}
@@ -0,0 +1,157 @@
+package apisession
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SessionHooks observes session lifecycle, any hook can be nil
+type SessionHooks struct {
+	//Called after a new session is saved
+	OnStart func(ctx context.Context, session *APISession)
+
+	//Called after an api call is accepted and saved
+	OnRecord func(ctx context.Context, request *APIRequest, session *APISession)
+
+	//Called when an api call is rejected, reason is the rejection error: ErrInvalidSession, ErrTooFast, ErrTooMany,
+	//ErrClientMismatch, ErrReauthRequired, ErrTooManyRecords, BanError, LockoutError, ScopeError or QuotaError.
+	//Match it with errors.Is and errors.As, see IsRejection
+	OnReject func(ctx context.Context, request *APIRequest, reason error)
+
+	//Called after a session is deleted
+	OnDelete func(ctx context.Context, owner string)
+
+	//Called when a session is expired by redis, see WatchExpiredSessions
+	OnExpire func(ctx context.Context, owner string)
+
+	//If true, hooks are called in new goroutines and don't delay the caller.
+	//Context passed to async hooks is never canceled. Async hooks get copies of request and session,
+	//shared by all async hooks of a call, so the caller can change its own. Hooks must not modify them.
+	Async bool
+}
+
+// AddHooks registers hooks, all registered hooks are called in registered order.
+// Should be called before the manager is in use.
+func (sm *RedisSessionManager) AddHooks(hooks SessionHooks) {
+	sm.hooks = append(sm.hooks, hooks)
+}
+
+func (sm *RedisSessionManager) runHooks(ctx context.Context, call func(ctx context.Context, hooks *SessionHooks)) {
+	for i := range sm.hooks {
+		hooks := &sm.hooks[i]
+		if hooks.Async {
+			go call(context.WithoutCancel(ctx), hooks)
+		} else {
+			call(ctx, hooks)
+		}
+	}
+}
+
+// Returns true if any registered hooks are async
+func (sm *RedisSessionManager) hasAsyncHooks() bool {
+	for i := range sm.hooks {
+		if sm.hooks[i].Async {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns copies of request and session for async hooks, which run while the caller may change them
+func (sm *RedisSessionManager) copyForAsyncHooks(request *APIRequest, session *APISession) (*APIRequest, *APISession) {
+	if !sm.hasAsyncHooks() {
+		return request, session
+	}
+	return request.clone(), session.clone()
+}
+
+func (sm *RedisSessionManager) notifyStart(ctx context.Context, session *APISession) {
+	_, asyncSession := sm.copyForAsyncHooks(nil, session)
+	sm.runHooks(ctx, func(ctx context.Context, hooks *SessionHooks) {
+		if hooks.OnStart == nil {
+			return
+		}
+		if hooks.Async {
+			hooks.OnStart(ctx, asyncSession)
+		} else {
+			hooks.OnStart(ctx, session)
+		}
+	})
+}
+
+func (sm *RedisSessionManager) notifyRecord(ctx context.Context, request *APIRequest, session *APISession) {
+	asyncRequest, asyncSession := sm.copyForAsyncHooks(request, session)
+	sm.runHooks(ctx, func(ctx context.Context, hooks *SessionHooks) {
+		if hooks.OnRecord == nil {
+			return
+		}
+		if hooks.Async {
+			hooks.OnRecord(ctx, asyncRequest, asyncSession)
+		} else {
+			hooks.OnRecord(ctx, request, session)
+		}
+	})
+}
+
+func (sm *RedisSessionManager) notifyReject(ctx context.Context, request *APIRequest, reason error) {
+	asyncRequest, _ := sm.copyForAsyncHooks(request, nil)
+	sm.runHooks(ctx, func(ctx context.Context, hooks *SessionHooks) {
+		if hooks.OnReject == nil {
+			return
+		}
+		if hooks.Async {
+			hooks.OnReject(ctx, asyncRequest, reason)
+		} else {
+			hooks.OnReject(ctx, request, reason)
+		}
+	})
+}
+
+func (sm *RedisSessionManager) notifyDelete(ctx context.Context, owner string) {
+	sm.runHooks(ctx, func(ctx context.Context, hooks *SessionHooks) {
+		if hooks.OnDelete != nil {
+			hooks.OnDelete(ctx, owner)
+		}
+	})
+}
+
+func (sm *RedisSessionManager) notifyExpire(ctx context.Context, owner string) {
+	sm.runHooks(ctx, func(ctx context.Context, hooks *SessionHooks) {
+		if hooks.OnExpire != nil {
+			hooks.OnExpire(ctx, owner)
+		}
+	})
+}
+
+// WatchExpiredSessions listens to redis keyspace notifications and calls OnExpire hooks
+// for expired sessions until ctx is done.
+//
+// Redis must have expired events enabled, eg: `CONFIG SET notify-keyspace-events Ex`.
+// Every instance running this receives the same notifications.
+func (sm *RedisSessionManager) WatchExpiredSessions(ctx context.Context) error {
+	channel := fmt.Sprintf("__keyevent@%d__:expired", sm.redisClient.Options().DB)
+	pubsub := sm.redisClient.Subscribe(ctx, channel)
+	defer pubsub.Close()
+	_, errReceive := pubsub.Receive(ctx)
+	if errReceive != nil {
+		return errReceive
+	}
+
+	keyPrefix := GetRedisSessionKey(sm.sessionKeyPrefix, "")
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case message, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			owner, isSession := strings.CutPrefix(message.Payload, keyPrefix)
+			if isSession {
+				sm.notifyExpire(ctx, owner)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package apisession
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestHooks_Lifecycle_Called$ github.com/zeroboo/go-api-session -v
+func TestHooks_Lifecycle_Called(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 1, 0, false)
+
+	started := []string{}
+	recorded := []string{}
+	rejected := []error{}
+	deleted := []string{}
+	manager.AddHooks(SessionHooks{
+		OnStart: func(ctx context.Context, session *APISession) {
+			started = append(started, session.Owner)
+		},
+		OnRecord: func(ctx context.Context, request *APIRequest, session *APISession) {
+			recorded = append(recorded, request.URL)
+		},
+		OnReject: func(ctx context.Context, request *APIRequest, reason error) {
+			rejected = append(rejected, reason)
+		},
+		OnDelete: func(ctx context.Context, owner string) {
+			deleted = append(deleted, owner)
+		},
+	})
+
+	sessionId, errStart := manager.StartSession(context.TODO(), owner)
+	assert.Nil(t, errStart, "Start session, no error")
+	sessionOwners = append(sessionOwners, owner)
+
+	manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	manager.RecordAPICall(context.TODO(), "invalid_session_id", owner, "url1")
+	manager.DeleteSession(context.TODO(), owner)
+
+	assert.Equal(t, []string{owner}, started, "OnStart called")
+	assert.Equal(t, []string{"url1"}, recorded, "OnRecord called for accepted call")
+	assert.Equal(t, []error{ErrTooMany, ErrInvalidSession}, rejected, "OnReject called with reasons")
+	assert.Equal(t, []string{owner}, deleted, "OnDelete called")
+}
+
+// go test -timeout 30s -run ^TestHooks_Async_Called$ github.com/zeroboo/go-api-session -v
+func TestHooks_Async_Called(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+
+	started := make(chan string, 1)
+	manager.AddHooks(SessionHooks{
+		OnStart: func(ctx context.Context, session *APISession) {
+			started <- session.Owner
+		},
+		Async: true,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, errStart := manager.StartSession(ctx, owner)
+	cancel()
+	assert.Nil(t, errStart, "Start session, no error")
+	sessionOwners = append(sessionOwners, owner)
+
+	select {
+	case startedOwner := <-started:
+		assert.Equal(t, owner, startedOwner, "Async OnStart called")
+	case <-time.After(time.Second):
+		t.Fatalf("Async OnStart not called")
+	}
+}
+
+// go test -timeout 30s -run ^TestHooks_Async_GetCopies$ github.com/zeroboo/go-api-session -v
+func TestHooks_Async_GetCopies(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+
+	release := make(chan struct{})
+	recorded := make(chan *APISession, 1)
+	manager.AddHooks(SessionHooks{
+		OnRecord: func(ctx context.Context, request *APIRequest, session *APISession) {
+			<-release
+			recorded <- session
+		},
+		Async: true,
+	})
+	started, _ := manager.StartSessionWithPayload(context.TODO(), owner, map[string]any{"level": 1})
+	sessionOwners = append(sessionOwners, owner)
+
+	session, errRecord := manager.RecordAPICall(context.TODO(), started.Id, owner, "url1")
+	assert.Nil(t, errRecord, "Record, no error")
+	session.SetPayload("level", 2)
+	session.GetCallRecord("url1").Count = 100
+	close(release)
+
+	select {
+	case hookSession := <-recorded:
+		assert.NotSame(t, session, hookSession, "Hook gets a copy")
+		assert.Equal(t, int64(1), hookSession.GetPayloadInt64("level"), "Payload change of caller not seen")
+		assert.Equal(t, int64(1), hookSession.GetCallRecord("url1").Count, "Record change of caller not seen")
+	case <-time.After(time.Second):
+		t.Fatalf("Async OnRecord not called")
+	}
+}
@@ -61,3 +61,44 @@ type ISessionManager interface {
 	// GetOnlineUsers returns a map of online users with their last activity timestamp
 	GetOnlineUsers(ctx context.Context) (map[string]int64, error)
 }
+
+// Optional methods of session managers, implemented by RedisSessionManager and forwarded by its
+// metrics and tracing wrappers. Wrappers return errors.ErrUnsupported if their wrapped manager lacks them.
+
+// APIRequestRecorder records api calls with client and cost, see RedisSessionManager.RecordAPIRequest
+type APIRequestRecorder interface {
+	RecordAPIRequest(ctx context.Context, request *APIRequest) (*APISession, error)
+}
+
+// SessionUpdater modifies sessions atomically, see RedisSessionManager.UpdateSession
+type SessionUpdater interface {
+	UpdateSession(ctx context.Context, owner string, mutate func(session *APISession) error) (*APISession, error)
+}
+
+// PayloadUpdater modifies payloads atomically, see RedisSessionManager.UpdatePayload
+type PayloadUpdater interface {
+	UpdatePayload(ctx context.Context, owner string, update func(payload map[string]any)) error
+}
+
+// CounterResetter clears api call counters of sessions, see RedisSessionManager.ResetCounters
+type CounterResetter interface {
+	ResetCounters(ctx context.Context, owner string, url string) error
+}
+
+// QuotaGranter gives sessions extra calls, see RedisSessionManager.GrantQuota
+type QuotaGranter interface {
+	GrantQuota(ctx context.Context, owner string, url string, calls int64, duration time.Duration) error
+}
+
+// CacheInvalidator publishes changes of sessions to instances with session cache, see RedisSessionManager.EnableCacheInvalidation
+type CacheInvalidator interface {
+	EnableCacheInvalidation()
+}
+
+var _ ISessionManager = (*RedisSessionManager)(nil)
+var _ APIRequestRecorder = (*RedisSessionManager)(nil)
+var _ SessionUpdater = (*RedisSessionManager)(nil)
+var _ PayloadUpdater = (*RedisSessionManager)(nil)
+var _ CounterResetter = (*RedisSessionManager)(nil)
+var _ QuotaGranter = (*RedisSessionManager)(nil)
+var _ CacheInvalidator = (*RedisSessionManager)(nil)
@@ -0,0 +1,425 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// StorageLayout is how a session is stored in redis
+type StorageLayout int
+
+const (
+	//Session is one encoded value, every save rewrites the whole session
+	LayoutBlob StorageLayout = iota
+
+	//Session fields, payload and api call counters are fields of a redis hash.
+	//RecordAPICall only transfers meta fields, payload and counters of the called url,
+	//and updates counters in place.
+	LayoutHash
+)
+
+// Fields of hash layout
+const (
+	hashFieldId      = "i"
+	hashFieldOwner   = "o"
+	hashFieldWindow  = "w"
+	hashFieldCreated = "c"
+	hashFieldUpdated = "u"
+	hashFieldVersion = "v"
+	hashFieldPayload = "p"
+	hashFieldData    = "d"
+	hashFieldGrants  = "g"
+	hashFieldClient  = "cl"
+
+	//Prefixes of per url fields: calls, last call and window of the calls
+	hashPrefixCount  = "c:"
+	hashPrefixLast   = "l:"
+	hashPrefixWindow = "w:"
+)
+
+// Fields loaded by RecordAPICall in hash layout, besides fields of the called url
+var hashRecordCallFields = []string{
+	hashFieldId, hashFieldOwner, hashFieldWindow, hashFieldCreated, hashFieldUpdated, hashFieldVersion,
+	hashFieldPayload, hashFieldData, hashFieldGrants, hashFieldClient,
+}
+
+// SetStorageLayout sets how sessions are stored, default is LayoutBlob.
+// Sessions stored with the other layout are still readable and converted on their next full save.
+// Should be called before the manager is in use.
+func (sm *RedisSessionManager) SetStorageLayout(layout StorageLayout) {
+	sm.layout = layout
+}
+
+func (sm *RedisSessionManager) GetStorageLayout() StorageLayout {
+	return sm.layout
+}
+
+func isWrongType(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
+
+// Loads full session with manager layout, falls back to the other layout if key has other type
+func (sm *RedisSessionManager) loadSession(ctx context.Context, cmd redis.Cmdable, owner string) (*APISession, error) {
+	key := sm.GetSessionKey(owner)
+	session, errLoad := sm.loadSessionLayout(ctx, cmd, key, sm.layout)
+	if isWrongType(errLoad) {
+		otherLayout := LayoutHash
+		if sm.layout == LayoutHash {
+			otherLayout = LayoutBlob
+		}
+		session, errLoad = sm.loadSessionLayout(ctx, cmd, key, otherLayout)
+	}
+	if errLoad != nil {
+		return nil, errLoad
+	}
+	session.codec = sm.codec
+	return session, nil
+}
+
+func (sm *RedisSessionManager) loadSessionLayout(ctx context.Context, cmd redis.Cmdable, key string, layout StorageLayout) (*APISession, error) {
+	if layout == LayoutHash {
+		fields, errGet := cmd.HGetAll(ctx, key).Result()
+		if errGet != nil {
+			return nil, errGet
+		}
+		if len(fields) == 0 {
+			return nil, redis.Nil
+		}
+		session, errDecode := decodeSessionHash(fields)
+		if errDecode != nil {
+			return nil, &decodeError{errDecode}
+		}
+		return session, nil
+	}
+
+	bytes, errGet := cmd.Get(ctx, key).Bytes()
+	if errGet != nil {
+		return nil, errGet
+	}
+	session := &APISession{}
+	errDecode := DecodeValue(bytes, session)
+	if errDecode != nil {
+		return nil, &decodeError{errDecode}
+	}
+	return session, nil
+}
+
+// Queues commands replacing stored session. If ttl is negative, current TTL is kept.
+func (sm *RedisSessionManager) queueWriteSession(ctx context.Context, pipe redis.Pipeliner, key string, session *APISession, ttl time.Duration) error {
+	if sm.layout == LayoutHash {
+		fields, errEncode := encodeSessionHash(sm.codec, session)
+		if errEncode != nil {
+			return errEncode
+		}
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, fields)
+		if ttl > 0 {
+			pipe.PExpire(ctx, key, ttl)
+		}
+		return nil
+	}
+
+	payload, errEncode := EncodeValue(sm.codec, session)
+	if errEncode != nil {
+		return errEncode
+	}
+	if ttl < 0 {
+		pipe.SetArgs(ctx, key, payload, redis.SetArgs{KeepTTL: true})
+	} else {
+		pipe.Set(ctx, key, payload, ttl)
+	}
+	return nil
+}
+
+func hashRecordFields(url string) (countField string, lastField string, windowField string) {
+	return hashPrefixCount + url, hashPrefixLast + url, hashPrefixWindow + url
+}
+
+func encodeSessionHash(codec Codec, session *APISession) (map[string]any, error) {
+	fields := map[string]any{
+		hashFieldId:      session.Id,
+		hashFieldOwner:   session.Owner,
+		hashFieldWindow:  session.Window,
+		hashFieldCreated: session.Created,
+		hashFieldUpdated: session.Updated,
+		hashFieldVersion: session.Version,
+	}
+	if session.Payload != nil {
+		payload, errEncode := EncodeValue(codec, session.Payload)
+		if errEncode != nil {
+			return nil, errEncode
+		}
+		fields[hashFieldPayload] = payload
+	}
+	if len(session.Data) > 0 {
+		fields[hashFieldData] = session.Data
+	}
+	if len(session.Grants) > 0 {
+		grants, errEncode := EncodeValue(codec, session.Grants)
+		if errEncode != nil {
+			return nil, errEncode
+		}
+		fields[hashFieldGrants] = grants
+	}
+	if session.Client != nil {
+		client, errEncode := EncodeValue(codec, session.Client)
+		if errEncode != nil {
+			return nil, errEncode
+		}
+		fields[hashFieldClient] = client
+	}
+	for url, record := range session.Records {
+		countField, lastField, windowField := hashRecordFields(url)
+		fields[countField] = record.Count
+		fields[lastField] = record.Last
+		fields[windowField] = session.Window
+	}
+	return fields, nil
+}
+
+func parseHashInt(fields map[string]string, field string) (int64, error) {
+	value, exist := fields[field]
+	if !exist {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// Decodes session from hash fields, counters of urls not called in session window are 0
+func decodeSessionHash(fields map[string]string) (*APISession, error) {
+	session := &APISession{
+		Id:      fields[hashFieldId],
+		Owner:   fields[hashFieldOwner],
+		Records: make(map[string]*APICallRecord),
+	}
+	var errParse error
+	for field, target := range map[string]*int64{
+		hashFieldWindow:  &session.Window,
+		hashFieldCreated: &session.Created,
+		hashFieldUpdated: &session.Updated,
+		hashFieldVersion: &session.Version,
+	} {
+		*target, errParse = parseHashInt(fields, field)
+		if errParse != nil {
+			return nil, errParse
+		}
+	}
+	if payload, exist := fields[hashFieldPayload]; exist {
+		errDecode := DecodeValue([]byte(payload), &session.Payload)
+		if errDecode != nil {
+			return nil, errDecode
+		}
+	}
+	if data, exist := fields[hashFieldData]; exist {
+		session.Data = []byte(data)
+	}
+	if grants, exist := fields[hashFieldGrants]; exist {
+		errDecode := DecodeValue([]byte(grants), &session.Grants)
+		if errDecode != nil {
+			return nil, errDecode
+		}
+	}
+	if client, exist := fields[hashFieldClient]; exist {
+		session.Client = &ClientInfo{}
+		errDecode := DecodeValue([]byte(client), session.Client)
+		if errDecode != nil {
+			return nil, errDecode
+		}
+	}
+
+	for field := range fields {
+		url, isCount := strings.CutPrefix(field, hashPrefixCount)
+		if !isCount {
+			continue
+		}
+		countField, lastField, windowField := hashRecordFields(url)
+		record := NewAPICallRecord()
+		record.Last, errParse = parseHashInt(fields, lastField)
+		if errParse != nil {
+			return nil, errParse
+		}
+		window, errWindow := parseHashInt(fields, windowField)
+		if errWindow != nil {
+			return nil, errWindow
+		}
+		if window == session.Window {
+			record.Count, errParse = parseHashInt(fields, countField)
+			if errParse != nil {
+				return nil, errParse
+			}
+		}
+		session.Records[url] = record
+	}
+	return session, nil
+}
+
+// Records an api call in hash layout: loads meta fields, payload and fields of the called url only,
+// validates and updates counters in place. Returned session only has record of the called url.
+// Errors from validate are returned as is.
+//
+// A new url on a session with capped records needs all records to evict, so the session is fully updated.
+func (sm *RedisSessionManager) recordAPICallHash(ctx context.Context, request *APIRequest, validate func(session *APISession) error) (*APISession, error) {
+	key := sm.GetSessionKey(request.Owner)
+	countField, lastField, windowField := hashRecordFields(sm.normalizeURL(request.URL))
+	fields := append(append([]string{}, hashRecordCallFields...), countField, lastField, windowField)
+
+	var session *APISession
+	var errValidate error
+	var onlineCmd *redis.IntCmd
+	fullUpdate := false
+	errWatch := sm.retryWatch(ctx, key, func(tx *redis.Tx) error {
+		errValidate = nil
+		onlineCmd = nil
+		fullUpdate = false
+		values, errGet := tx.HMGet(ctx, key, fields...).Result()
+		if errGet != nil {
+			return errGet
+		}
+		hashFields := make(map[string]string)
+		for i, value := range values {
+			if value != nil {
+				hashFields[fields[i]] = value.(string)
+			}
+		}
+		if _, exist := hashFields[hashFieldId]; !exist {
+			return redis.Nil
+		}
+		var errDecode error
+		session, errDecode = decodeSessionHash(hashFields)
+		if errDecode != nil {
+			return &decodeError{errDecode}
+		}
+		session.codec = sm.codec
+		storedCount, _ := parseHashInt(hashFields, countField)
+		storedWindow, _ := parseHashInt(hashFields, windowField)
+		_, recordExist := hashFields[countField]
+		if !recordExist && sm.maxRecords > 0 {
+			fullUpdate = true
+			return nil
+		}
+
+		_, grantsExist := hashFields[hashFieldGrants]
+
+		errValidate = validate(session)
+		if errValidate != nil {
+			return errValidate
+		}
+		//Validation may use or drop grants
+		var grants []byte
+		if len(session.Grants) > 0 {
+			var errEncode error
+			grants, errEncode = EncodeValue(sm.codec, session.Grants)
+			if errEncode != nil {
+				return errEncode
+			}
+		}
+
+		record := session.GetCallRecord(sm.normalizeURL(request.URL))
+		session.Updated = time.Now().UnixMilli()
+		session.Version++
+		_, errExec := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if recordExist && storedWindow == session.Window {
+				pipe.HIncrBy(ctx, key, countField, record.Count-storedCount)
+			} else {
+				pipe.HSet(ctx, key, countField, record.Count)
+			}
+			pipe.HSet(ctx, key,
+				lastField, record.Last,
+				windowField, session.Window,
+				hashFieldWindow, session.Window,
+				hashFieldUpdated, session.Updated)
+			if grants != nil {
+				pipe.HSet(ctx, key, hashFieldGrants, grants)
+			} else if grantsExist {
+				pipe.HDel(ctx, key, hashFieldGrants)
+			}
+			pipe.HIncrBy(ctx, key, hashFieldVersion, 1)
+			if sm.sessionTTL > 0 {
+				pipe.PExpire(ctx, key, sm.sessionTTL)
+			}
+			if sm.trackOnlineUsers {
+				onlineCmd = pipe.ZAdd(ctx, sm.onlineUserKey, redis.Z{
+					Score:  float64(session.Updated),
+					Member: session.Owner,
+				})
+			}
+			return nil
+		})
+		return errExec
+	})
+
+	if errValidate != nil {
+		return nil, errValidate
+	}
+	if isWrongType(errWatch) || (errWatch == nil && fullUpdate) {
+		//Session was saved in blob layout or records need eviction, full update rewrites it
+		return sm.watchSession(ctx, request.Owner, validate, true)
+	}
+	if errWatch != nil {
+		if !errors.Is(errWatch, ErrConflict) {
+			sm.logLoadError(ctx, "RecordAPICall", request.Owner, errWatch)
+		}
+		return nil, errWatch
+	}
+	if onlineCmd != nil && onlineCmd.Val() > 0 {
+		sm.emitPresence(ctx, session.Owner, PresenceOnline, session.Updated)
+	}
+	return session, nil
+}
+
+// Changes payload in hash layout, only payload field is transferred
+func (sm *RedisSessionManager) updatePayloadHash(ctx context.Context, owner string, update func(payload map[string]any)) error {
+	key := sm.GetSessionKey(owner)
+	errWatch := sm.retryWatch(ctx, key, func(tx *redis.Tx) error {
+		values, errGet := tx.HMGet(ctx, key, hashFieldId, hashFieldPayload).Result()
+		if errGet != nil {
+			return errGet
+		}
+		if values[0] == nil {
+			return redis.Nil
+		}
+		payload := make(map[string]any)
+		if values[1] != nil {
+			errDecode := DecodeValue([]byte(values[1].(string)), &payload)
+			if errDecode != nil {
+				return &decodeError{errDecode}
+			}
+			if payload == nil {
+				payload = make(map[string]any)
+			}
+		}
+		update(payload)
+		encoded, errEncode := EncodeValue(sm.codec, payload)
+		if errEncode != nil {
+			return errEncode
+		}
+
+		_, errExec := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, hashFieldPayload, encoded, hashFieldUpdated, time.Now().UnixMilli())
+			pipe.HIncrBy(ctx, key, hashFieldVersion, 1)
+			return nil
+		})
+		return errExec
+	})
+
+	if isWrongType(errWatch) {
+		//Session was saved in blob layout, full update converts it
+		_, errUpdate := sm.watchSession(ctx, owner, func(session *APISession) error {
+			if session.Payload == nil {
+				session.Payload = make(map[string]any)
+			}
+			update(session.Payload)
+			return nil
+		}, false)
+		return errUpdate
+	}
+	if errWatch != nil && !errors.Is(errWatch, ErrConflict) {
+		sm.logLoadError(ctx, "UpdatePayload", owner, errWatch)
+	}
+	return errWatch
+}
@@ -0,0 +1,98 @@
+package apisession
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestHashLayout_RecordAPICall_Correct$ github.com/zeroboo/go-api-session -v
+func TestHashLayout_RecordAPICall_Correct(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 2, 0, false)
+	manager.SetStorageLayout(LayoutHash)
+
+	started, errStart := manager.StartSessionWithPayload(context.TODO(), owner, map[string]any{"nickname": "Jaian"})
+	assert.Nil(t, errStart, "Start session, no error")
+	sessionOwners = append(sessionOwners, owner)
+	keyType, _ := redisClient.Type(context.TODO(), manager.GetSessionKey(owner)).Result()
+	assert.Equal(t, "hash", keyType, "Stored as hash")
+
+	session, errRecord := manager.RecordAPICall(context.TODO(), started.Id, owner, "url1")
+	assert.Nil(t, errRecord, "First call, no error")
+	assert.Equal(t, "Jaian", session.GetPayloadString("nickname"), "Payload loaded")
+	manager.RecordAPICall(context.TODO(), started.Id, owner, "url2")
+	_, errRecord = manager.RecordAPICall(context.TODO(), started.Id, owner, "url1")
+	assert.Nil(t, errRecord, "Second call, no error")
+	_, errRecord = manager.RecordAPICall(context.TODO(), started.Id, owner, "url1")
+	assert.Equal(t, ErrTooMany, errRecord, "Third call, too many")
+	_, errRecord = manager.RecordAPICall(context.TODO(), "invalid_session_id", owner, "url1")
+	assert.Equal(t, ErrInvalidSession, errRecord, "Invalid session, error")
+
+	count, _ := redisClient.HGet(context.TODO(), manager.GetSessionKey(owner), "c:url1").Int64()
+	assert.Equal(t, int64(2), count, "Counter field updated in place")
+
+	loaded, errGet := manager.GetSession(context.TODO(), owner)
+	assert.Nil(t, errGet, "Get session, no error")
+	assert.Equal(t, int64(2), loaded.GetCallRecord("url1").Count, "url1 counter")
+	assert.Equal(t, int64(1), loaded.GetCallRecord("url2").Count, "url2 counter")
+	assert.Equal(t, int64(4), loaded.Version, "Version increased by every write")
+
+	errUpdate := manager.SetPayloadField(context.TODO(), owner, "level", 3)
+	assert.Nil(t, errUpdate, "Set payload field, no error")
+	loaded, _ = manager.GetSession(context.TODO(), owner)
+	assert.Equal(t, 3, loaded.GetPayloadInt("level"), "Payload field set")
+	assert.Equal(t, int64(2), loaded.GetCallRecord("url1").Count, "Counter kept")
+}
+
+// go test -timeout 30s -run ^TestHashLayout_BlobSession_Converted$ github.com/zeroboo/go-api-session -v
+func TestHashLayout_BlobSession_Converted(t *testing.T) {
+	owner := "user_" + t.Name()
+	blobManager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	sessionId, _ := blobManager.StartSession(context.TODO(), owner)
+	sessionOwners = append(sessionOwners, owner)
+	blobManager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+
+	hashManager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	hashManager.SetStorageLayout(LayoutHash)
+	loaded, errGet := hashManager.GetSession(context.TODO(), owner)
+	assert.Nil(t, errGet, "Read blob session, no error")
+	assert.Equal(t, sessionId, loaded.Id, "Blob session read")
+
+	session, errRecord := hashManager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	assert.Nil(t, errRecord, "Record on blob session, no error")
+	assert.Equal(t, int64(2), session.GetCallRecord("url1").Count, "Counter kept")
+	keyType, _ := redisClient.Type(context.TODO(), hashManager.GetSessionKey(owner)).Result()
+	assert.Equal(t, "hash", keyType, "Converted to hash")
+}
+
+func benchmarkRecordAPICall(b *testing.B, layout StorageLayout) {
+	owner := fmt.Sprintf("user_%v", b.Name())
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 60000, 60000, int64(b.N)+1, 0, false)
+	manager.SetStorageLayout(layout)
+	session := NewAPISession(owner)
+	for i := 0; i < 200; i++ {
+		session.GetCallRecord(fmt.Sprintf("/api/resource%d", i)).Count = 1
+	}
+	manager.SetSession(context.TODO(), owner, session)
+	sessionOwners = append(sessionOwners, owner)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, errRecord := manager.RecordAPICall(context.TODO(), session.Id, owner, "/api/resource0")
+		if errRecord != nil {
+			b.Fatalf("Record api call failed: %v", errRecord)
+		}
+	}
+}
+
+// go test -run ^$ -bench ^BenchmarkRecordAPICall github.com/zeroboo/go-api-session
+func BenchmarkRecordAPICall_Blob(b *testing.B) {
+	benchmarkRecordAPICall(b, LayoutBlob)
+}
+
+func BenchmarkRecordAPICall_Hash(b *testing.B) {
+	benchmarkRecordAPICall(b, LayoutHash)
+}
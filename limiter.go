@@ -0,0 +1,145 @@
+package apisession
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Limiter rate limits calls by arbitrary keys like IP or API key, without sessions.
+// Calls are counted like api calls of sessions: max calls per time window and minimum interval
+// between calls, rejected with ErrTooMany and ErrTooFast.
+//
+// Counters are stored in redis, so they are shared by all instances using the same prefix.
+type Limiter struct {
+	redisClient *redis.Client
+	keyPrefix   string
+
+	//In milliseconds
+	windowSize int64
+
+	//Max calls of a key in a time window, budget of weighted calls
+	maxCallPerWindow int64
+
+	//minimum milliseconds between 2 calls of a key, 0 mean no limit
+	requestInterval int64
+
+	logger *slog.Logger
+}
+
+// Creates a limiter
+// Params:
+//   - redisClient: redis client
+//   - keyPrefix: prefix of limiter keys, limiters with different limits should use different prefixes
+//   - windowSize: time window in milliseconds
+//   - maxCallPerWindow: max calls of a key per window
+//   - requestInterval: minimum milliseconds between 2 calls of a key, 0 mean no limit
+func NewLimiter(redisClient *redis.Client, keyPrefix string, windowSize int64, maxCallPerWindow int64, requestInterval int64) *Limiter {
+	return &Limiter{
+		redisClient:      redisClient,
+		keyPrefix:        keyPrefix,
+		windowSize:       windowSize,
+		maxCallPerWindow: maxCallPerWindow,
+		requestInterval:  requestInterval,
+		logger:           discardLogger,
+	}
+}
+
+// SetLogger sets logger of the limiter, nil disables logging. Logging is disabled by default.
+func (l *Limiter) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = discardLogger
+	}
+	l.logger = logger
+}
+
+// GetKey returns redis key of counters of key, it doesn't start with a session prefix
+// so limiters and session managers can share a prefix
+func (l *Limiter) GetKey(key string) string {
+	return fmt.Sprintf("limit:%s:%s", l.keyPrefix, key)
+}
+
+// Allow counts a call of key if limits allow it, like AllowN with cost 1
+func (l *Limiter) Allow(ctx context.Context, key string) (*APICallRecord, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Counts a call of a limiter key like countCall, in one step so concurrent calls are never lost.
+// ARGV: now, window, window size, max calls, request interval, cost, all in milliseconds.
+// Returns {0 if counted, 1 if too fast, 2 if too many; calls counted in window; last call time}.
+var limiterAllowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local windowSize = tonumber(ARGV[3])
+local maxCalls = tonumber(ARGV[4])
+local interval = tonumber(ARGV[5])
+local cost = tonumber(ARGV[6])
+local stored = redis.call("HMGET", KEYS[1], "w", "c", "l")
+local count = 0
+if tonumber(stored[1]) == window then
+	count = tonumber(stored[2]) or 0
+end
+local last = tonumber(stored[3]) or 0
+if interval > 0 and now - last < interval then
+	return {1, count, last}
+end
+if count + cost > maxCalls then
+	return {2, count, last}
+end
+count = count + cost
+redis.call("HSET", KEYS[1], "w", window, "c", count, "l", now)
+redis.call("PEXPIREAT", KEYS[1], math.max((window + 1) * windowSize, now + interval))
+return {0, count, now}
+`)
+
+// AllowN counts a call of key using cost of the budget of a window if limits allow it.
+// Checking and counting is one redis call, concurrent calls of a key are all counted.
+// Costs not positive are ignored like in session managers, the call costs DefaultCallCost.
+//
+// Returns:
+//   - record: budget used by key in current window and last call time, nil if rejected
+//   - error: nil if allowed, ErrTooMany or ErrTooFast if rejected
+func (l *Limiter) AllowN(ctx context.Context, key string, cost int64) (*APICallRecord, error) {
+	if cost <= 0 {
+		cost = DefaultCallCost
+	}
+	now := time.Now().UnixMilli()
+	window := now / l.windowSize
+	result, errScript := limiterAllowScript.Run(ctx, l.redisClient, []string{l.GetKey(key)},
+		now, window, l.windowSize, l.maxCallPerWindow, l.requestInterval, cost).Int64Slice()
+	if errScript != nil {
+		l.logger.ErrorContext(ctx, "limiter failed", "key", key, "error", errScript)
+		return nil, errScript
+	}
+
+	var errLimit error
+	switch result[0] {
+	case 1:
+		errLimit = ErrTooFast
+	case 2:
+		errLimit = ErrTooMany
+	}
+	if errLimit != nil {
+		l.logger.InfoContext(ctx, "call rejected", "key", key, "reason", errLimit)
+		return nil, errLimit
+	}
+	return &APICallRecord{Count: result[1], Last: result[2]}, nil
+}
+
+// Reset clears counters of key so its calls are allowed again
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	errDel := l.redisClient.Del(ctx, l.GetKey(key)).Err()
+	if errDel != nil {
+		l.logger.ErrorContext(ctx, "limiter failed", "key", key, "error", errDel)
+		return errDel
+	}
+	return nil
+}
+
+// GetRemaining returns budget left in the window of a record returned by AllowN
+func (l *Limiter) GetRemaining(record *APICallRecord) int64 {
+	return max(l.maxCallPerWindow-record.Count, 0)
+}
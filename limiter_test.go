@@ -0,0 +1,136 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestLimiter_TooMany$ github.com/zeroboo/go-api-session -v
+func TestLimiter_TooMany(t *testing.T) {
+	key := "ip_" + t.Name()
+	limiter := NewLimiter(redisClient, sessionPrefix, 3600000, 3, 0)
+	defer limiter.Reset(context.TODO(), key)
+
+	for i := int64(1); i <= 3; i++ {
+		record, errAllow := limiter.Allow(context.TODO(), key)
+		assert.Nil(t, errAllow, "Call under limit allowed")
+		assert.Equal(t, i, record.Count, "Calls counted")
+	}
+	record, errAllow := limiter.Allow(context.TODO(), key)
+	assert.True(t, errors.Is(errAllow, ErrTooMany), "Call over limit rejected")
+	assert.Nil(t, record, "No record of rejected call")
+
+	_, errOther := limiter.Allow(context.TODO(), key+"_other")
+	assert.Nil(t, errOther, "Other keys counted separately")
+	limiter.Reset(context.TODO(), key+"_other")
+
+	errReset := limiter.Reset(context.TODO(), key)
+	assert.Nil(t, errReset, "Reset, no error")
+	_, errAllow = limiter.Allow(context.TODO(), key)
+	assert.Nil(t, errAllow, "Call allowed after reset")
+}
+
+// go test -timeout 30s -run ^TestLimiter_TooFast$ github.com/zeroboo/go-api-session -v
+func TestLimiter_TooFast(t *testing.T) {
+	key := "ip_" + t.Name()
+	limiter := NewLimiter(redisClient, sessionPrefix, 3600000, 10, 200)
+	defer limiter.Reset(context.TODO(), key)
+
+	_, errFirst := limiter.Allow(context.TODO(), key)
+	assert.Nil(t, errFirst, "First call allowed")
+	_, errSecond := limiter.Allow(context.TODO(), key)
+	assert.True(t, errors.Is(errSecond, ErrTooFast), "Call within interval rejected")
+
+	time.Sleep(250 * time.Millisecond)
+	record, errThird := limiter.Allow(context.TODO(), key)
+	assert.Nil(t, errThird, "Call after interval allowed")
+	assert.Equal(t, int64(2), record.Count, "Rejected call not counted")
+}
+
+// go test -timeout 30s -run ^TestLimiter_WindowExpired$ github.com/zeroboo/go-api-session -v
+func TestLimiter_WindowExpired(t *testing.T) {
+	key := "ip_" + t.Name()
+	limiter := NewLimiter(redisClient, sessionPrefix, 200, 1, 0)
+	defer limiter.Reset(context.TODO(), key)
+	//Start at beginning of a window so both calls are in it
+	time.Sleep(time.Duration(200-time.Now().UnixMilli()%200) * time.Millisecond)
+
+	_, errFirst := limiter.Allow(context.TODO(), key)
+	assert.Nil(t, errFirst, "First call allowed")
+	_, errSecond := limiter.Allow(context.TODO(), key)
+	assert.True(t, errors.Is(errSecond, ErrTooMany), "Call over limit rejected")
+
+	time.Sleep(250 * time.Millisecond)
+	record, errNext := limiter.Allow(context.TODO(), key)
+	assert.Nil(t, errNext, "Call in next window allowed")
+	assert.Equal(t, int64(1), record.Count, "Counter restarted")
+
+	ttl := redisClient.PTTL(context.TODO(), limiter.GetKey(key)).Val()
+	assert.True(t, ttl > 0 && ttl <= time.Second, "Key expires with window")
+}
+
+// go test -timeout 30s -run ^TestLimiter_AllowN$ github.com/zeroboo/go-api-session -v
+func TestLimiter_AllowN(t *testing.T) {
+	key := "key_" + t.Name()
+	limiter := NewLimiter(redisClient, sessionPrefix, 3600000, 100, 0)
+	defer limiter.Reset(context.TODO(), key)
+
+	record, errAllow := limiter.AllowN(context.TODO(), key, 60)
+	assert.Nil(t, errAllow, "Call within budget allowed")
+	assert.Equal(t, int64(40), limiter.GetRemaining(record), "Remaining budget")
+	_, errAllow = limiter.AllowN(context.TODO(), key, 60)
+	assert.True(t, errors.Is(errAllow, ErrTooMany), "Call over budget rejected")
+	record, errAllow = limiter.Allow(context.TODO(), key)
+	assert.Nil(t, errAllow, "Cheaper call allowed")
+	assert.Equal(t, int64(61), record.Count, "Budget used")
+}
+
+// go test -timeout 30s -run ^TestLimiter_AllowN_CostNotPositive$ github.com/zeroboo/go-api-session -v
+func TestLimiter_AllowN_CostNotPositive(t *testing.T) {
+	key := "key_" + t.Name()
+	limiter := NewLimiter(redisClient, sessionPrefix, 3600000, 3, 0)
+	defer limiter.Reset(context.TODO(), key)
+
+	record, errAllow := limiter.AllowN(context.TODO(), key, 0)
+	assert.Nil(t, errAllow, "Zero cost allowed")
+	assert.Equal(t, int64(1), record.Count, "Zero cost counted as default cost")
+	record, errAllow = limiter.AllowN(context.TODO(), key, -10)
+	assert.Nil(t, errAllow, "Negative cost allowed")
+	assert.Equal(t, int64(2), record.Count, "Negative cost counted as default cost, no budget refunded")
+
+	limiter.Allow(context.TODO(), key)
+	_, errAllow = limiter.AllowN(context.TODO(), key, -10)
+	assert.True(t, errors.Is(errAllow, ErrTooMany), "Negative cost doesn't pass exhausted budget")
+}
+
+// go test -timeout 30s -run ^TestLimiter_Concurrent_AllCounted$ github.com/zeroboo/go-api-session -v
+func TestLimiter_Concurrent_AllCounted(t *testing.T) {
+	key := "ip_" + t.Name()
+	limiter := NewLimiter(redisClient, sessionPrefix, 3600000, 30, 0)
+	defer limiter.Reset(context.TODO(), key)
+
+	var wg sync.WaitGroup
+	var allowed, tooMany atomic.Int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errAllow := limiter.Allow(context.TODO(), key)
+			if errAllow == nil {
+				allowed.Add(1)
+			} else if errors.Is(errAllow, ErrTooMany) {
+				tooMany.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(30), allowed.Load(), "Calls up to limit allowed")
+	assert.Equal(t, int64(20), tooMany.Load(), "Calls over limit rejected, none failed")
+}
@@ -0,0 +1,60 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Drops all records, used when no logger is set
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discardLogger = slog.New(discardHandler{})
+
+// SetLogger sets logger of the manager, nil disables logging. Logging is disabled by default.
+//
+// Logged events:
+//   - rejected api calls: info
+//   - missing sessions: debug
+//   - session decoding failures: error
+//   - redis errors: error
+func (sm *RedisSessionManager) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = discardLogger
+	}
+	sm.logger = logger
+}
+
+// Logs a failed redis command, missing key is not a failure
+func (sm *RedisSessionManager) logRedisError(ctx context.Context, operation string, owner string, err error) {
+	if errors.Is(err, redis.Nil) {
+		sm.logger.DebugContext(ctx, "session not found", "operation", operation, "owner", owner)
+		return
+	}
+	sm.logger.ErrorContext(ctx, "redis command failed", "operation", operation, "owner", owner, "error", err)
+}
+
+// Wraps errors of decoding a stored session, to be logged apart from redis errors
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string { return e.err.Error() }
+func (e *decodeError) Unwrap() error { return e.err }
+
+// Logs a failed session load
+func (sm *RedisSessionManager) logLoadError(ctx context.Context, operation string, owner string, err error) {
+	var errDecode *decodeError
+	if errors.As(err, &errDecode) {
+		sm.logger.ErrorContext(ctx, "failed to decode session", "operation", operation, "owner", owner, "error", errDecode.err)
+		return
+	}
+	sm.logRedisError(ctx, operation, owner, err)
+}
@@ -0,0 +1,31 @@
+package apisession
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestLogger_RejectedCall_Logged$ github.com/zeroboo/go-api-session -v
+func TestLogger_RejectedCall_Logged(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	output := &bytes.Buffer{}
+	manager.SetLogger(slog.New(slog.NewTextHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	_, errStart := manager.StartSession(context.TODO(), owner)
+	assert.Nil(t, errStart, "Start session, no error")
+	sessionOwners = append(sessionOwners, owner)
+
+	_, errRecord := manager.RecordAPICall(context.TODO(), "invalid_session_id", owner, "url1")
+	assert.Equal(t, ErrInvalidSession, errRecord, "Invalid session, error")
+	assert.Contains(t, output.String(), `level=INFO msg="api call rejected"`, "Rejection logged")
+	assert.Contains(t, output.String(), "owner="+owner, "Owner logged")
+
+	_, errGet := manager.GetSession(context.TODO(), "user_not_exist")
+	assert.NotNil(t, errGet, "Missing session, error")
+	assert.Contains(t, output.String(), `level=DEBUG msg="session not found"`, "Missing session logged")
+}
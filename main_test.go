@@ -3,11 +3,11 @@ package apisession
 import (
 	"context"
 	"errors"
+	log "log/slog"
 	"os"
 	"testing"
 
 	redis "github.com/redis/go-redis/v9"
-	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,11 +16,7 @@ var sessionOwners []string = []string{}
 var sessionPrefix string = "sess"
 
 func TestMain(m *testing.M) {
-	log.Infof("TestMain: Init done!!!")
-	log.SetFormatter(&log.TextFormatter{
-		DisableTimestamp: true,
-		FullTimestamp:    false,
-	})
+	log.Info("TestMain: Init done!!!")
 
 	redisClient = redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
@@ -38,9 +34,9 @@ func CleanUpTest() {
 		key := GetRedisSessionKey(sessionPrefix, owner)
 		cmd := redisClient.Del(context.TODO(), key)
 		if cmd.Err() != nil {
-			log.Infof("Failed to delete session %s: %v", owner, cmd.Err())
+			log.Info("Failed to delete session", "owner", owner, "error", cmd.Err())
 		} else {
-			log.Infof("Deleted session of %s, key=%v", owner, key)
+			log.Info("Deleted session", "owner", owner, "key", key)
 		}
 	}
 }
@@ -71,5 +67,5 @@ func TestDeleteSession(t *testing.T) {
 	assert.True(t, errors.Is(errGet, redis.Nil), "Get deleted session should return nil")
 	assert.Nil(t, session, "Session should be nil after deletion")
 
-	log.Infof("Successfully deleted session for owner %s with ID %s", owner, sessionId)
+	t.Logf("Successfully deleted session for owner %s with ID %s", owner, sessionId)
 }
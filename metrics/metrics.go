@@ -0,0 +1,328 @@
+// Package metrics exports prometheus metrics of an apisession.ISessionManager
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apisession "github.com/zeroboo/go-api-session"
+)
+
+const (
+	OutcomeOK             = "ok"
+	OutcomeTooFast        = "too_fast"
+	OutcomeTooMany        = "too_many"
+	OutcomeInvalidSession = "invalid_session"
+	OutcomeBanned         = "banned"
+	OutcomeLockedOut      = "locked_out"
+	OutcomeClientMismatch = "client_mismatch"
+	OutcomeReauthRequired = "reauth_required"
+	OutcomeQuotaExceeded  = "quota_exceeded"
+	OutcomeTooManyRecords = "too_many_records"
+	OutcomeError          = "error"
+
+	// Label of urls exceeding cardinality limit
+	OtherURL = "other"
+)
+
+type Options struct {
+	//Namespace of metric names, default is "apisession"
+	Namespace string
+
+	//Maps an url to its label value, return OtherURL to group it.
+	//If nil, first MaxURLs distinct urls are kept and the rest are reported as OtherURL
+	URLLabel func(url string) string
+
+	//Max distinct url labels when URLLabel is nil, default is 100
+	MaxURLs int
+
+	//If true, exports a gauge of online users. Online users tracking must be enabled in the manager
+	OnlineUsers bool
+
+	//Timeout of GetOnlineUsers when collecting online users gauge, default is 1 second
+	OnlineUsersTimeout time.Duration
+}
+
+// InstrumentedSessionManager wraps an ISessionManager and records metrics of its calls
+type InstrumentedSessionManager struct {
+	next apisession.ISessionManager
+
+	calls    *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	urlLabel func(url string) string
+}
+
+// Creates an instrumented session manager and registers its metrics.
+//
+// Params:
+//   - next: the wrapped manager
+//   - registerer: registry receives the metrics
+//   - options: metric options
+//
+// Returns:
+//   - manager: wrapped manager
+//   - error: error from registering metrics
+func NewInstrumentedSessionManager(next apisession.ISessionManager, registerer prometheus.Registerer, options Options) (*InstrumentedSessionManager, error) {
+	if options.Namespace == "" {
+		options.Namespace = "apisession"
+	}
+	if options.MaxURLs <= 0 {
+		options.MaxURLs = 100
+	}
+	if options.OnlineUsersTimeout <= 0 {
+		options.OnlineUsersTimeout = time.Second
+	}
+
+	manager := &InstrumentedSessionManager{
+		next: next,
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Name:      "api_calls_total",
+			Help:      "API calls validated by session manager, by outcome and url.",
+		}, []string{"outcome", "url"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: options.Namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of session manager operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"operation", "status"}),
+		urlLabel: options.URLLabel,
+	}
+	if manager.urlLabel == nil {
+		manager.urlLabel = newBoundedURLLabel(options.MaxURLs)
+	}
+
+	collectors := []prometheus.Collector{manager.calls, manager.duration}
+	if options.OnlineUsers {
+		collectors = append(collectors, &onlineUsersCollector{
+			next:    next,
+			timeout: options.OnlineUsersTimeout,
+			desc: prometheus.NewDesc(prometheus.BuildFQName(options.Namespace, "", "online_users"),
+				"Number of online users.", nil, nil),
+		})
+	}
+	for _, collector := range collectors {
+		errRegister := registerer.Register(collector)
+		if errRegister != nil {
+			return nil, errRegister
+		}
+	}
+	return manager, nil
+}
+
+var _ apisession.ISessionManager = (*InstrumentedSessionManager)(nil)
+var _ apisession.APIRequestRecorder = (*InstrumentedSessionManager)(nil)
+var _ apisession.SessionUpdater = (*InstrumentedSessionManager)(nil)
+var _ apisession.PayloadUpdater = (*InstrumentedSessionManager)(nil)
+var _ apisession.CounterResetter = (*InstrumentedSessionManager)(nil)
+var _ apisession.QuotaGranter = (*InstrumentedSessionManager)(nil)
+var _ apisession.CacheInvalidator = (*InstrumentedSessionManager)(nil)
+
+// Collects gauge of online users, no sample is reported if online users can't be loaded
+type onlineUsersCollector struct {
+	next    apisession.ISessionManager
+	timeout time.Duration
+	desc    *prometheus.Desc
+}
+
+func (c *onlineUsersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *onlineUsersCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	onlineUsers, errGet := c.next.GetOnlineUsers(ctx)
+	if errGet != nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(len(onlineUsers)))
+}
+
+// Keeps first maxURLs distinct urls, groups the rest as OtherURL
+func newBoundedURLLabel(maxURLs int) func(url string) string {
+	var mu sync.Mutex
+	known := make(map[string]struct{})
+	return func(url string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, exist := known[url]; exist {
+			return url
+		}
+		if len(known) >= maxURLs {
+			return OtherURL
+		}
+		known[url] = struct{}{}
+		return url
+	}
+}
+
+// Outcome returns outcome label of an api call error, failures of the manager are OutcomeError
+func Outcome(err error) string {
+	if err == nil {
+		return OutcomeOK
+	}
+	if !apisession.IsRejection(err) {
+		return OutcomeError
+	}
+	return apisession.ErrorKind(err)
+}
+
+func (m *InstrumentedSessionManager) observe(operation string, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.duration.WithLabelValues(operation, status).Observe(time.Since(start).Seconds())
+}
+
+func (m *InstrumentedSessionManager) countCall(url string, err error) {
+	m.calls.WithLabelValues(Outcome(err), m.urlLabel(url)).Inc()
+}
+
+func (m *InstrumentedSessionManager) RecordAPICall(ctx context.Context, sessionId string, owner string, url string) (*apisession.APISession, error) {
+	start := time.Now()
+	session, err := m.next.RecordAPICall(ctx, sessionId, owner, url)
+	m.observe("record_api_call", start, err)
+	m.countCall(url, err)
+	return session, err
+}
+
+// RecordAPIRequest records an api call with client and cost, see RedisSessionManager.RecordAPIRequest.
+// Falls back to RecordAPICall if the wrapped manager doesn't support requests.
+func (m *InstrumentedSessionManager) RecordAPIRequest(ctx context.Context, request *apisession.APIRequest) (*apisession.APISession, error) {
+	start := time.Now()
+	var session *apisession.APISession
+	var err error
+	if recorder, ok := m.next.(apisession.APIRequestRecorder); ok {
+		session, err = recorder.RecordAPIRequest(ctx, request)
+	} else {
+		session, err = m.next.RecordAPICall(ctx, request.SessionId, request.Owner, request.URL)
+	}
+	m.observe("record_api_call", start, err)
+	m.countCall(request.URL, err)
+	return session, err
+}
+
+func (m *InstrumentedSessionManager) ValidateAPICall(request *apisession.APIRequest, session *apisession.APISession, now time.Time) error {
+	err := m.next.ValidateAPICall(request, session, now)
+	m.countCall(request.URL, err)
+	return err
+}
+
+func (m *InstrumentedSessionManager) GetSession(ctx context.Context, owner string) (*apisession.APISession, error) {
+	start := time.Now()
+	session, err := m.next.GetSession(ctx, owner)
+	m.observe("get_session", start, err)
+	return session, err
+}
+
+func (m *InstrumentedSessionManager) DeleteSession(ctx context.Context, owner string) error {
+	start := time.Now()
+	err := m.next.DeleteSession(ctx, owner)
+	m.observe("delete_session", start, err)
+	return err
+}
+
+func (m *InstrumentedSessionManager) SetSession(ctx context.Context, owner string, session *apisession.APISession) error {
+	start := time.Now()
+	err := m.next.SetSession(ctx, owner, session)
+	m.observe("set_session", start, err)
+	return err
+}
+
+func (m *InstrumentedSessionManager) StartSession(ctx context.Context, owner string) (string, error) {
+	start := time.Now()
+	sessionId, err := m.next.StartSession(ctx, owner)
+	m.observe("start_session", start, err)
+	return sessionId, err
+}
+
+func (m *InstrumentedSessionManager) StartSessionWithPayload(ctx context.Context, owner string, payload map[string]any) (*apisession.APISession, error) {
+	start := time.Now()
+	session, err := m.next.StartSessionWithPayload(ctx, owner, payload)
+	m.observe("start_session", start, err)
+	return session, err
+}
+
+// UpdateSession atomically modifies session of owner, see RedisSessionManager.UpdateSession.
+// Returns errors.ErrUnsupported if the wrapped manager doesn't support it.
+func (m *InstrumentedSessionManager) UpdateSession(ctx context.Context, owner string, mutate func(session *apisession.APISession) error) (*apisession.APISession, error) {
+	updater, ok := m.next.(apisession.SessionUpdater)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	start := time.Now()
+	session, err := updater.UpdateSession(ctx, owner, mutate)
+	m.observe("update_session", start, err)
+	return session, err
+}
+
+// UpdatePayload atomically modifies payload of session of owner, see RedisSessionManager.UpdatePayload.
+// Returns errors.ErrUnsupported if the wrapped manager doesn't support it.
+func (m *InstrumentedSessionManager) UpdatePayload(ctx context.Context, owner string, update func(payload map[string]any)) error {
+	updater, ok := m.next.(apisession.PayloadUpdater)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	start := time.Now()
+	err := updater.UpdatePayload(ctx, owner, update)
+	m.observe("update_payload", start, err)
+	return err
+}
+
+// ResetCounters clears api call counters of owner, see RedisSessionManager.ResetCounters.
+// Returns errors.ErrUnsupported if the wrapped manager doesn't support it.
+func (m *InstrumentedSessionManager) ResetCounters(ctx context.Context, owner string, url string) error {
+	resetter, ok := m.next.(apisession.CounterResetter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	start := time.Now()
+	err := resetter.ResetCounters(ctx, owner, url)
+	m.observe("reset_counters", start, err)
+	return err
+}
+
+// GrantQuota gives owner extra calls, see RedisSessionManager.GrantQuota.
+// Returns errors.ErrUnsupported if the wrapped manager doesn't support it.
+func (m *InstrumentedSessionManager) GrantQuota(ctx context.Context, owner string, url string, calls int64, duration time.Duration) error {
+	granter, ok := m.next.(apisession.QuotaGranter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	start := time.Now()
+	err := granter.GrantQuota(ctx, owner, url, calls, duration)
+	m.observe("grant_quota", start, err)
+	return err
+}
+
+// EnableCacheInvalidation enables cache invalidation of the wrapped manager if it supports it
+func (m *InstrumentedSessionManager) EnableCacheInvalidation() {
+	if invalidator, ok := m.next.(apisession.CacheInvalidator); ok {
+		invalidator.EnableCacheInvalidation()
+	}
+}
+
+func (m *InstrumentedSessionManager) GetRequestInterval() int64 {
+	return m.next.GetRequestInterval()
+}
+
+func (m *InstrumentedSessionManager) GetMaxCallPerWindow() int64 {
+	return m.next.GetMaxCallPerWindow()
+}
+
+func (m *InstrumentedSessionManager) GetWindowSize() int64 {
+	return m.next.GetWindowSize()
+}
+
+func (m *InstrumentedSessionManager) GetOnlineUsers(ctx context.Context) (map[string]int64, error) {
+	start := time.Now()
+	onlineUsers, err := m.next.GetOnlineUsers(ctx)
+	m.observe("get_online_users", start, err)
+	return onlineUsers, err
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	apisession "github.com/zeroboo/go-api-session"
+)
+
+// Returns preset results instead of calling redis
+type stubSessionManager struct {
+	apisession.ISessionManager
+	recordErrors []error
+	onlineUsers  map[string]int64
+	onlineError  error
+	requests     []*apisession.APIRequest
+}
+
+func (s *stubSessionManager) RecordAPICall(ctx context.Context, sessionId string, owner string, url string) (*apisession.APISession, error) {
+	err := s.recordErrors[0]
+	s.recordErrors = s.recordErrors[1:]
+	if err != nil {
+		return nil, err
+	}
+	return apisession.NewAPISession(owner), nil
+}
+
+func (s *stubSessionManager) GetOnlineUsers(ctx context.Context) (map[string]int64, error) {
+	return s.onlineUsers, s.onlineError
+}
+
+func (s *stubSessionManager) RecordAPIRequest(ctx context.Context, request *apisession.APIRequest) (*apisession.APISession, error) {
+	s.requests = append(s.requests, request)
+	return s.RecordAPICall(ctx, request.SessionId, request.Owner, request.URL)
+}
+
+// go test -timeout 30s -run ^TestInstrumented_RecordAPICall_CountByOutcome$ github.com/zeroboo/go-api-session/metrics -v
+func TestInstrumented_RecordAPICall_CountByOutcome(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	stub := &stubSessionManager{
+		recordErrors: []error{nil, apisession.ErrTooFast, apisession.ErrTooMany, nil},
+	}
+	manager, errCreate := NewInstrumentedSessionManager(stub, registry, Options{MaxURLs: 1})
+	assert.Nil(t, errCreate, "Create manager, no error")
+
+	manager.RecordAPICall(context.TODO(), "id", "user1", "url1")
+	manager.RecordAPICall(context.TODO(), "id", "user1", "url1")
+	manager.RecordAPICall(context.TODO(), "id", "user1", "url1")
+	manager.RecordAPICall(context.TODO(), "id", "user1", "url2")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(manager.calls.WithLabelValues(OutcomeOK, "url1")), "ok url1")
+	assert.Equal(t, 1.0, testutil.ToFloat64(manager.calls.WithLabelValues(OutcomeTooFast, "url1")), "too fast url1")
+	assert.Equal(t, 1.0, testutil.ToFloat64(manager.calls.WithLabelValues(OutcomeTooMany, "url1")), "too many url1")
+	assert.Equal(t, 1.0, testutil.ToFloat64(manager.calls.WithLabelValues(OutcomeOK, OtherURL)), "url2 over limit is other")
+	assert.Equal(t, 2, testutil.CollectAndCount(manager.duration), "Latency observed for ok and error status")
+}
+
+// go test -timeout 30s -run ^TestInstrumented_OnlineUsers_Gauge$ github.com/zeroboo/go-api-session/metrics -v
+func TestInstrumented_OnlineUsers_Gauge(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	stub := &stubSessionManager{
+		onlineUsers: map[string]int64{"user1": time.Now().UnixMilli(), "user2": time.Now().UnixMilli()},
+	}
+	_, errCreate := NewInstrumentedSessionManager(stub, registry, Options{OnlineUsers: true})
+	assert.Nil(t, errCreate, "Create manager, no error")
+
+	families, errGather := registry.Gather()
+	assert.Nil(t, errGather, "Gather, no error")
+	found := false
+	for _, family := range families {
+		if family.GetName() == "apisession_online_users" {
+			found = true
+			assert.Equal(t, 2.0, family.GetMetric()[0].GetGauge().GetValue(), "Online users count")
+		}
+	}
+	assert.True(t, found, "Online users gauge registered")
+
+	_, errDuplicate := NewInstrumentedSessionManager(stub, registry, Options{OnlineUsers: true})
+	assert.NotNil(t, errDuplicate, "Register twice, error")
+}
+
+// go test -timeout 30s -run ^TestInstrumented_OnlineUsers_SkippedOnError$ github.com/zeroboo/go-api-session/metrics -v
+func TestInstrumented_OnlineUsers_SkippedOnError(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	stub := &stubSessionManager{onlineError: errors.New("redis down")}
+	_, errCreate := NewInstrumentedSessionManager(stub, registry, Options{OnlineUsers: true})
+	assert.Nil(t, errCreate, "Create manager, no error")
+
+	families, errGather := registry.Gather()
+	assert.Nil(t, errGather, "Gather, no error")
+	for _, family := range families {
+		assert.NotEqual(t, "apisession_online_users", family.GetName(), "No online users sample on error")
+	}
+}
+
+// go test -timeout 30s -run ^TestInstrumented_RecordAPIRequest_Forwarded$ github.com/zeroboo/go-api-session/metrics -v
+func TestInstrumented_RecordAPIRequest_Forwarded(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	stub := &stubSessionManager{recordErrors: []error{apisession.ErrQuotaExceeded}}
+	manager, errCreate := NewInstrumentedSessionManager(stub, registry, Options{})
+	assert.Nil(t, errCreate, "Create manager, no error")
+
+	request := &apisession.APIRequest{Owner: "user1", SessionId: "id", URL: "url1", Cost: 5}
+	_, errRecord := manager.RecordAPIRequest(context.TODO(), request)
+
+	assert.ErrorIs(t, errRecord, apisession.ErrQuotaExceeded, "Error of wrapped manager")
+	assert.Equal(t, []*apisession.APIRequest{request}, stub.requests, "Request forwarded with cost")
+	assert.Equal(t, 1.0, testutil.ToFloat64(manager.calls.WithLabelValues(OutcomeQuotaExceeded, "url1")), "quota exceeded url1")
+
+	_, errUpdate := manager.UpdateSession(context.TODO(), "user1", func(session *apisession.APISession) error { return nil })
+	assert.ErrorIs(t, errUpdate, errors.ErrUnsupported, "Wrapped manager can't update")
+	errReset := manager.ResetCounters(context.TODO(), "user1", apisession.AllURLs)
+	assert.ErrorIs(t, errReset, errors.ErrUnsupported, "Wrapped manager can't reset")
+}
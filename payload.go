@@ -0,0 +1,188 @@
+package apisession
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var ErrPayloadNotFound = fmt.Errorf("payload not found")
+var ErrPayloadType = fmt.Errorf("payload has wrong type")
+
+// GetPayloadAs returns payload value of key as type T.
+//
+// Decoded sessions don't keep payload types: codecs return numbers as any integer or float type,
+// slices as []any and maps as map[string]any. Numbers are converted to T if the value fits,
+// slices and maps are converted element by element.
+//
+// Returns:
+//   - value T: converted value, zero value if error
+//   - error: ErrPayloadNotFound if key doesn't exist, ErrPayloadType if value can't be converted
+func GetPayloadAs[T any](sess *APISession, key string) (T, error) {
+	var zero T
+	value, exist := sess.Payload[key]
+	if !exist {
+		return zero, fmt.Errorf("%w: %s", ErrPayloadNotFound, key)
+	}
+	typedValue, ok := convertPayloadValue[T](value)
+	if !ok {
+		return zero, fmt.Errorf("%w: %s is %T", ErrPayloadType, key, value)
+	}
+	return typedValue, nil
+}
+
+// convertPayloadValue converts a decoded payload value to type T, see GetPayloadAs
+func convertPayloadValue[T any](value any) (T, bool) {
+	typedValue, ok := value.(T)
+	if ok {
+		return typedValue, true
+	}
+
+	var zero T
+	converted, ok := convertValue(value, reflect.TypeOf(&zero).Elem())
+	if !ok {
+		return zero, false
+	}
+	return converted.Interface().(T), true
+}
+
+func convertValue(value any, target reflect.Type) (reflect.Value, bool) {
+	if value == nil {
+		switch target.Kind() {
+		case reflect.Interface, reflect.Map, reflect.Slice, reflect.Pointer:
+			return reflect.Zero(target), true
+		}
+		return reflect.Value{}, false
+	}
+
+	source := reflect.ValueOf(value)
+	if source.Type().AssignableTo(target) {
+		return source, true
+	}
+
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		intValue, ok := toInt64(value)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		converted := reflect.New(target).Elem()
+		if converted.OverflowInt(intValue) {
+			return reflect.Value{}, false
+		}
+		converted.SetInt(intValue)
+		return converted, true
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		uintValue, ok := toUint64(value)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		converted := reflect.New(target).Elem()
+		if converted.OverflowUint(uintValue) {
+			return reflect.Value{}, false
+		}
+		converted.SetUint(uintValue)
+		return converted, true
+
+	case reflect.Float32, reflect.Float64:
+		floatValue, ok := toFloat64(value)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		converted := reflect.New(target).Elem()
+		converted.SetFloat(floatValue)
+		return converted, true
+
+	case reflect.Slice:
+		if source.Kind() != reflect.Slice && source.Kind() != reflect.Array {
+			return reflect.Value{}, false
+		}
+		converted := reflect.MakeSlice(target, source.Len(), source.Len())
+		for i := 0; i < source.Len(); i++ {
+			element, ok := convertValue(source.Index(i).Interface(), target.Elem())
+			if !ok {
+				return reflect.Value{}, false
+			}
+			converted.Index(i).Set(element)
+		}
+		return converted, true
+
+	case reflect.Map:
+		if source.Kind() != reflect.Map {
+			return reflect.Value{}, false
+		}
+		converted := reflect.MakeMapWithSize(target, source.Len())
+		iter := source.MapRange()
+		for iter.Next() {
+			mapKey, okKey := convertValue(iter.Key().Interface(), target.Key())
+			mapValue, okValue := convertValue(iter.Value().Interface(), target.Elem())
+			if !okKey || !okValue {
+				return reflect.Value{}, false
+			}
+			converted.SetMapIndex(mapKey, mapValue)
+		}
+		return converted, true
+	}
+	return reflect.Value{}, false
+}
+
+// Converts any integer type, or a float without fraction, to int64
+func toInt64(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), uint64(v) <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case float32:
+		return toInt64(float64(v))
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func toUint64(value any) (uint64, bool) {
+	switch v := value.(type) {
+	case uint:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	}
+	intValue, ok := toInt64(value)
+	return uint64(intValue), ok && intValue >= 0
+}
+
+func toFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case uint64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	}
+	intValue, ok := toInt64(value)
+	return float64(intValue), ok
+}
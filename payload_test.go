@@ -0,0 +1,89 @@
+package apisession
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Encodes and decodes session like a redis round trip
+func roundTripSession(t *testing.T, codec Codec, session *APISession) *APISession {
+	data, errEncode := EncodeValue(codec, session)
+	assert.Nil(t, errEncode, "Encode, no error: %T", codec)
+	decoded := &APISession{}
+	errDecode := DecodeValue(data, decoded)
+	assert.Nil(t, errDecode, "Decode, no error: %T", codec)
+	return decoded
+}
+
+// go test -timeout 30s -run ^TestPayload_AccessorsAfterRoundTrip_Correct$ github.com/zeroboo/go-api-session -v
+func TestPayload_AccessorsAfterRoundTrip_Correct(t *testing.T) {
+	for _, codec := range []Codec{MsgpackCodec{}, JSONCodec{}, CBORCodec{}, ProtobufCodec{}} {
+		session := NewAPISessionWithPayload("user1", map[string]any{
+			"small":    1,
+			"int":      70000,
+			"int64":    int64(1) << 40,
+			"negative": -5,
+			"name":     "Jaian",
+			"tokens":   []string{"token1", "token2"},
+			"scores":   map[string]int64{"a": 1, "b": 300},
+		})
+		decoded := roundTripSession(t, codec, session)
+
+		assert.Equal(t, 1, decoded.GetPayloadInt("small"), "GetPayloadInt small: %T", codec)
+		assert.Equal(t, 70000, decoded.GetPayloadInt("int"), "GetPayloadInt: %T", codec)
+		assert.Equal(t, int64(1)<<40, decoded.GetPayloadInt64("int64"), "GetPayloadInt64: %T", codec)
+		assert.Equal(t, int64(-5), decoded.GetPayloadInt64("negative"), "GetPayloadInt64 negative: %T", codec)
+		assert.Equal(t, "Jaian", decoded.GetPayloadString("name"), "GetPayloadString: %T", codec)
+
+		tokens, ok := GetPayloadSlice[string](decoded, "tokens")
+		assert.True(t, ok, "GetPayloadSlice ok: %T", codec)
+		assert.Equal(t, []string{"token1", "token2"}, tokens, "GetPayloadSlice: %T", codec)
+
+		scores := GetPayloadMap[string, int64](decoded, "scores")
+		assert.Equal(t, map[string]int64{"a": 1, "b": 300}, scores, "GetPayloadMap: %T", codec)
+
+		scoresCreated, created := GetOrCreatePayloadMap[string, int64](decoded, "scores")
+		assert.False(t, created, "GetOrCreatePayloadMap existing: %T", codec)
+		assert.Equal(t, map[string]int64{"a": 1, "b": 300}, scoresCreated, "GetOrCreatePayloadMap: %T", codec)
+
+		small, errSmall := GetPayloadAs[uint8](decoded, "small")
+		assert.Nil(t, errSmall, "GetPayloadAs uint8, no error: %T", codec)
+		assert.Equal(t, uint8(1), small, "GetPayloadAs uint8: %T", codec)
+
+		big, errBig := GetPayloadAs[int64](decoded, "int64")
+		assert.Nil(t, errBig, "GetPayloadAs int64, no error: %T", codec)
+		assert.Equal(t, int64(1)<<40, big, "GetPayloadAs int64: %T", codec)
+
+		name, errName := GetPayloadAs[string](decoded, "name")
+		assert.Nil(t, errName, "GetPayloadAs string, no error: %T", codec)
+		assert.Equal(t, "Jaian", name, "GetPayloadAs string: %T", codec)
+	}
+}
+
+// go test -timeout 30s -run ^TestPayload_InvalidTypes_NoPanic$ github.com/zeroboo/go-api-session -v
+func TestPayload_InvalidTypes_NoPanic(t *testing.T) {
+	session := NewAPISessionWithPayload("user1", map[string]any{
+		"name":     "Jaian",
+		"int":      70000,
+		"fraction": 1.5,
+		"mixed":    []any{"token1", 2},
+	})
+	decoded := roundTripSession(t, MsgpackCodec{}, session)
+
+	assert.Equal(t, 0, decoded.GetPayloadInt("name"), "String is not int")
+	assert.Equal(t, int64(0), decoded.GetPayloadInt64("fraction"), "Fraction is not int")
+
+	_, ok := GetPayloadSlice[string](decoded, "name")
+	assert.False(t, ok, "String is not slice")
+	_, ok = GetPayloadSlice[string](decoded, "mixed")
+	assert.False(t, ok, "Mixed slice is not slice of string")
+
+	_, errOverflow := GetPayloadAs[int8](decoded, "int")
+	assert.True(t, errors.Is(errOverflow, ErrPayloadType), "Overflow, type error")
+	_, errType := GetPayloadAs[int](decoded, "name")
+	assert.True(t, errors.Is(errType, ErrPayloadType), "String is not int, type error")
+	_, errMissing := GetPayloadAs[int](decoded, "missing")
+	assert.True(t, errors.Is(errMissing, ErrPayloadNotFound), "Missing key, not found error")
+}
@@ -0,0 +1,189 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// PenaltyPolicy locks an owner out after repeated ErrTooMany or ErrTooFast violations
+type PenaltyPolicy struct {
+	//Violations within Period triggering a lockout
+	MaxViolations int64
+	Period        time.Duration
+
+	//Duration of first lockout, doubled by every next lockout
+	BaseLockout time.Duration
+	//Max lockout duration, 0 means no limit
+	MaxLockout time.Duration
+
+	//Lockouts are escalated until an owner has no lockout for this duration, default is 24 hours
+	ResetAfter time.Duration
+}
+
+// LockoutError is returned for calls of a locked out owner, it matches ErrLockedOut with errors.Is
+type LockoutError struct {
+	//Time calls are allowed again
+	Until time.Time
+
+	//Violation causing the lockout, nil if owner was already locked out
+	Cause error
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%s until %s", ErrLockedOut.Error(), e.Until.Format(time.RFC3339))
+}
+
+func (e *LockoutError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{ErrLockedOut}
+	}
+	return []error{ErrLockedOut, e.Cause}
+}
+
+// Counts a violation and locks owner out when violations reach max.
+// Returns lockout expired time in milliseconds, 0 if owner is not locked out.
+var recordViolationScript = redis.NewScript(`
+local violations = redis.call("INCR", KEYS[1])
+if violations == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+if violations < tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("DEL", KEYS[1])
+
+local level = redis.call("INCR", KEYS[3])
+redis.call("PEXPIRE", KEYS[3], ARGV[5])
+local duration = tonumber(ARGV[3]) * math.pow(2, math.min(level - 1, 40))
+local maxDuration = tonumber(ARGV[4])
+if maxDuration > 0 and duration > maxDuration then
+	duration = maxDuration
+end
+duration = math.floor(duration)
+local expires = tonumber(ARGV[6]) + duration
+redis.call("SET", KEYS[2], expires, "PX", duration)
+return expires
+`)
+
+// EnablePenalties makes RecordAPICall lock owners out by policy.
+// Violations and lockouts are stored in redis, so they apply to all instances enabling them.
+// Checking a lockout costs one more redis call per api call, shared with bans check.
+// Should be called before the manager is in use.
+func (sm *RedisSessionManager) EnablePenalties(policy PenaltyPolicy) error {
+	if policy.MaxViolations <= 0 || policy.Period <= 0 || policy.BaseLockout <= 0 {
+		return fmt.Errorf("penalty policy needs positive max violations, period and base lockout")
+	}
+	if policy.ResetAfter <= 0 {
+		policy.ResetAfter = 24 * time.Hour
+	}
+	sm.penaltyPolicy = &policy
+	return nil
+}
+
+// Returns keys of violations, lockout and lockout level of owner
+func (sm *RedisSessionManager) getPenaltyKeys(owner string) []string {
+	return []string{
+		fmt.Sprintf("penalty:%s:%s", sm.sessionKeyPrefix, owner),
+		fmt.Sprintf("lockout:%s:%s", sm.sessionKeyPrefix, owner),
+		fmt.Sprintf("lockout-level:%s:%s", sm.sessionKeyPrefix, owner),
+	}
+}
+
+// Counts violation of owner if penalties are enabled.
+// Returns LockoutError if owner is locked out by it, otherwise the violation.
+func (sm *RedisSessionManager) recordViolation(ctx context.Context, owner string, violation error) error {
+	if sm.penaltyPolicy == nil {
+		return violation
+	}
+	policy := sm.penaltyPolicy
+	expires, errScript := recordViolationScript.Run(ctx, sm.redisClient, sm.getPenaltyKeys(owner),
+		policy.Period.Milliseconds(),
+		policy.MaxViolations,
+		policy.BaseLockout.Milliseconds(),
+		policy.MaxLockout.Milliseconds(),
+		policy.ResetAfter.Milliseconds(),
+		time.Now().UnixMilli()).Int64()
+	if errScript != nil {
+		//Call is still rejected by the violation
+		sm.logRedisError(ctx, "RecordViolation", owner, errScript)
+		return violation
+	}
+	if expires == 0 {
+		return violation
+	}
+	sm.logger.InfoContext(ctx, "owner locked out", "owner", owner, "until", expires)
+	return &LockoutError{Until: time.UnixMilli(expires), Cause: violation}
+}
+
+// GetLockout returns time a locked out owner is allowed again, redis.Nil if owner is not locked out
+func (sm *RedisSessionManager) GetLockout(ctx context.Context, owner string) (time.Time, error) {
+	expires, errGet := sm.redisClient.Get(ctx, sm.getPenaltyKeys(owner)[1]).Int64()
+	if errGet != nil {
+		return time.Time{}, errGet
+	}
+	return time.UnixMilli(expires), nil
+}
+
+// ClearPenalties lifts lockout of an owner and forgets the owner's violations and lockout level
+func (sm *RedisSessionManager) ClearPenalties(ctx context.Context, owner string) error {
+	errDel := sm.redisClient.Del(ctx, sm.getPenaltyKeys(owner)...).Err()
+	if errDel != nil {
+		sm.logRedisError(ctx, "ClearPenalties", owner, errDel)
+		return errDel
+	}
+	return nil
+}
+
+// Returns BanError or LockoutError if owner is banned or locked out, checked in one round trip
+func (sm *RedisSessionManager) checkOwner(ctx context.Context, owner string) error {
+	if !sm.bansEnabled && sm.penaltyPolicy == nil {
+		return nil
+	}
+	var banCmd, lockoutCmd *redis.StringCmd
+	sm.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if sm.bansEnabled {
+			banCmd = pipe.Get(ctx, sm.getBanKey(owner))
+		}
+		if sm.penaltyPolicy != nil {
+			lockoutCmd = pipe.Get(ctx, sm.getPenaltyKeys(owner)[1])
+		}
+		return nil
+	})
+
+	if banCmd != nil {
+		value, errGet := banCmd.Bytes()
+		if errGet == nil {
+			ban, errParse := parseBan(value)
+			if errParse != nil {
+				sm.logLoadError(ctx, "CheckBan", owner, errParse)
+				return errParse
+			}
+			return &BanError{Ban: ban}
+		}
+		if !errors.Is(errGet, redis.Nil) {
+			sm.logRedisError(ctx, "CheckBan", owner, errGet)
+			return errGet
+		}
+	}
+	if lockoutCmd != nil {
+		value, errGet := lockoutCmd.Result()
+		if errGet == nil {
+			expires, errParse := strconv.ParseInt(value, 10, 64)
+			if errParse != nil {
+				sm.logLoadError(ctx, "CheckLockout", owner, &decodeError{errParse})
+				return errParse
+			}
+			return &LockoutError{Until: time.UnixMilli(expires)}
+		}
+		if !errors.Is(errGet, redis.Nil) {
+			sm.logRedisError(ctx, "CheckLockout", owner, errGet)
+			return errGet
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestPenalties_LockoutEscalated$ github.com/zeroboo/go-api-session -v
+func TestPenalties_LockoutEscalated(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 1, 0, false)
+	errEnable := manager.EnablePenalties(PenaltyPolicy{
+		MaxViolations: 2,
+		Period:        time.Minute,
+		BaseLockout:   time.Minute,
+		MaxLockout:    3 * time.Minute,
+	})
+	assert.Nil(t, errEnable, "Enable penalties, no error")
+	defer manager.ClearPenalties(context.TODO(), owner)
+	sessionId, _ := manager.StartSession(context.TODO(), owner)
+	sessionOwners = append(sessionOwners, owner)
+
+	lockout := func() *LockoutError {
+		//Violations until locked out
+		for i := 0; i < 3; i++ {
+			_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+			var lockoutError *LockoutError
+			if errors.As(errRecord, &lockoutError) {
+				assert.True(t, errors.Is(errRecord, ErrTooMany), "Lockout caused by violation")
+				return lockoutError
+			}
+		}
+		return nil
+	}
+
+	start := time.Now()
+	first := lockout()
+	assert.NotNil(t, first, "Locked out")
+	assert.WithinDuration(t, start.Add(time.Minute), first.Until, time.Second, "First lockout")
+	_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url2")
+	assert.True(t, errors.Is(errRecord, ErrLockedOut), "Other urls rejected while locked out")
+	until, errGet := manager.GetLockout(context.TODO(), owner)
+	assert.Nil(t, errGet, "Get lockout, no error")
+	assert.Equal(t, first.Until.UnixMilli(), until.UnixMilli(), "Lockout expiry")
+
+	//Lockout expires, next lockout is longer
+	redisClient.Del(context.TODO(), manager.getPenaltyKeys(owner)[1])
+	second := lockout()
+	assert.NotNil(t, second, "Locked out again")
+	assert.WithinDuration(t, start.Add(2*time.Minute), second.Until, time.Second, "Doubled lockout")
+
+	redisClient.Del(context.TODO(), manager.getPenaltyKeys(owner)[1])
+	third := lockout()
+	assert.WithinDuration(t, start.Add(3*time.Minute), third.Until, time.Second, "Capped lockout")
+
+	errClear := manager.ClearPenalties(context.TODO(), owner)
+	assert.Nil(t, errClear, "Clear penalties, no error")
+	manager.ResetCounters(context.TODO(), owner, AllURLs)
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	assert.Nil(t, errRecord, "Allowed after clear")
+}
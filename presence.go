@@ -0,0 +1,185 @@
+package apisession
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+type PresenceEventType string
+
+const (
+	PresenceOnline  PresenceEventType = "online"
+	PresenceOffline PresenceEventType = "offline"
+)
+
+// Emitted when an owner comes online or goes offline
+type PresenceEvent struct {
+	Owner string            `json:"o"`
+	Type  PresenceEventType `json:"t"`
+	Time  int64             `json:"ts"` //Event time in milliseconds
+}
+
+// Receives presence events, called synchronously so it must not block
+type PresenceListener func(event PresenceEvent)
+
+// Removes an owner from online set only if the owner has been idle since cutoff,
+// so an owner updated between the range query and the removal stays online
+var removeIdleOwnerScript = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if score and tonumber(score) <= tonumber(ARGV[2]) then
+	return redis.call("ZREM", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// EnablePresence turns on online/offline events. Online users tracking must be enabled.
+//
+// An owner becomes online when the owner's session is saved while the owner is not in the online set,
+// so repeated api calls don't produce more events. The owner becomes offline when the session is
+// deleted or when SweepPresence finds the owner idle for longer than timeout.
+//
+// Events are emitted after sessions are saved, so failing to publish them is logged and
+// doesn't fail the save.
+//
+// Params:
+//   - timeout: milliseconds of inactivity before an owner is considered offline
+//   - listener: receives events detected by this instance, can be nil
+//   - publish: if true, events are also published to redis channel GetPresenceChannel()
+//     so all instances can receive them via SubscribePresence
+func (sm *RedisSessionManager) EnablePresence(timeout int64, listener PresenceListener, publish bool) error {
+	if !sm.trackOnlineUsers {
+		return fmt.Errorf("online users tracking is disabled")
+	}
+	sm.presenceEnabled = true
+	sm.presenceTimeout = timeout
+	sm.presenceListener = listener
+	sm.presencePublish = publish
+	return nil
+}
+
+// GetPresenceChannel returns redis pub/sub channel of presence events
+func (sm *RedisSessionManager) GetPresenceChannel() string {
+	return fmt.Sprintf("%s:events", sm.onlineUserKey)
+}
+
+// Emits a presence event, failures are logged since the change causing the event is already saved
+func (sm *RedisSessionManager) emitPresence(ctx context.Context, owner string, eventType PresenceEventType, now int64) {
+	if !sm.presenceEnabled {
+		return
+	}
+	event := PresenceEvent{
+		Owner: owner,
+		Type:  eventType,
+		Time:  now,
+	}
+	if sm.presenceListener != nil {
+		sm.presenceListener(event)
+	}
+	if sm.presencePublish {
+		payload, errMarshal := json.Marshal(event)
+		if errMarshal != nil {
+			sm.logger.ErrorContext(ctx, "failed to encode presence event", "owner", owner, "error", errMarshal)
+			return
+		}
+		cmd := sm.redisClient.Publish(ctx, sm.GetPresenceChannel(), payload)
+		if cmd.Err() != nil {
+			sm.logRedisError(ctx, "PublishPresence", owner, cmd.Err())
+		}
+	}
+}
+
+// SweepPresence removes owners idle for longer than presence timeout from online set
+// and emits offline events for them.
+//
+// Returns:
+//   - owners []string: owners went offline
+//   - error: nil if success, an error instance if any
+func (sm *RedisSessionManager) SweepPresence(ctx context.Context) ([]string, error) {
+	if !sm.presenceEnabled {
+		return nil, fmt.Errorf("presence is disabled")
+	}
+	now := time.Now().UnixMilli()
+	cutoff := now - sm.presenceTimeout
+	cmd := sm.redisClient.ZRangeByScore(ctx, sm.onlineUserKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("%d", cutoff),
+	})
+	if cmd.Err() != nil {
+		sm.logRedisError(ctx, "SweepPresence", "", cmd.Err())
+		return nil, cmd.Err()
+	}
+
+	offlineOwners := []string{}
+	for _, owner := range cmd.Val() {
+		removed, errRemove := removeIdleOwnerScript.Run(ctx, sm.redisClient, []string{sm.onlineUserKey}, owner, cutoff).Int64()
+		if errRemove != nil {
+			sm.logRedisError(ctx, "SweepPresence", owner, errRemove)
+			return offlineOwners, errRemove
+		}
+		if removed == 0 {
+			continue
+		}
+		offlineOwners = append(offlineOwners, owner)
+		sm.emitPresence(ctx, owner, PresenceOffline, now)
+	}
+	return offlineOwners, nil
+}
+
+// RunPresenceSweeper calls SweepPresence every interval until ctx is done
+func (sm *RedisSessionManager) RunPresenceSweeper(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			//Errors are logged by SweepPresence, next tick retries
+			sm.SweepPresence(ctx)
+		}
+	}
+}
+
+// SubscribePresence receives presence events published by all instances.
+// The returned channel is closed when ctx is done.
+func (sm *RedisSessionManager) SubscribePresence(ctx context.Context) (<-chan PresenceEvent, error) {
+	pubsub := sm.redisClient.Subscribe(ctx, sm.GetPresenceChannel())
+	_, errReceive := pubsub.Receive(ctx)
+	if errReceive != nil {
+		pubsub.Close()
+		return nil, errReceive
+	}
+
+	events := make(chan PresenceEvent)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				event := PresenceEvent{}
+				errUnmarshal := json.Unmarshal([]byte(message.Payload), &event)
+				if errUnmarshal != nil {
+					sm.logger.WarnContext(ctx, "invalid presence event", "payload", message.Payload, "error", errUnmarshal)
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
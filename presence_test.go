@@ -0,0 +1,94 @@
+package apisession
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type presenceRecorder struct {
+	mu     sync.Mutex
+	events []PresenceEvent
+}
+
+func (r *presenceRecorder) Listen(event PresenceEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *presenceRecorder) Events(owner string) []PresenceEventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := []PresenceEventType{}
+	for _, event := range r.events {
+		if event.Owner == owner {
+			types = append(types, event.Type)
+		}
+	}
+	return types
+}
+
+// go test -timeout 30s -run ^TestPresence_OnlineOnceThenOfflineOnDelete$ github.com/zeroboo/go-api-session -v
+func TestPresence_OnlineOnceThenOfflineOnDelete(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, true)
+	recorder := &presenceRecorder{}
+	errEnable := manager.EnablePresence(60000, recorder.Listen, false)
+	assert.Nil(t, errEnable, "Enable presence, no error")
+
+	sessionId, errStart := manager.StartSession(context.TODO(), owner)
+	assert.Nil(t, errStart, "Start session, no error")
+	sessionOwners = append(sessionOwners, owner)
+
+	for i := 0; i < 3; i++ {
+		_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+		assert.Nil(t, errRecord, "Record api call, no error")
+	}
+	assert.Equal(t, []PresenceEventType{PresenceOnline}, recorder.Events(owner), "Only one online event")
+
+	errDelete := manager.DeleteSession(context.TODO(), owner)
+	assert.Nil(t, errDelete, "Delete session, no error")
+	assert.Equal(t, []PresenceEventType{PresenceOnline, PresenceOffline}, recorder.Events(owner), "Offline after delete")
+}
+
+// go test -timeout 30s -run ^TestPresence_Sweep_OfflineAfterTimeout$ github.com/zeroboo/go-api-session -v
+func TestPresence_Sweep_OfflineAfterTimeout(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, true)
+	recorder := &presenceRecorder{}
+	manager.EnablePresence(50, recorder.Listen, true)
+
+	events, errSubscribe := manager.SubscribePresence(context.TODO())
+	assert.Nil(t, errSubscribe, "Subscribe, no error")
+
+	_, errStart := manager.StartSession(context.TODO(), owner)
+	assert.Nil(t, errStart, "Start session, no error")
+	sessionOwners = append(sessionOwners, owner)
+
+	offlineOwners, errSweep := manager.SweepPresence(context.TODO())
+	assert.Nil(t, errSweep, "Sweep, no error")
+	assert.NotContains(t, offlineOwners, owner, "Active owner stays online")
+
+	time.Sleep(100 * time.Millisecond)
+	offlineOwners, errSweep = manager.SweepPresence(context.TODO())
+	assert.Nil(t, errSweep, "Sweep, no error")
+	assert.Contains(t, offlineOwners, owner, "Idle owner goes offline")
+	assert.Equal(t, []PresenceEventType{PresenceOnline, PresenceOffline}, recorder.Events(owner), "Online then offline")
+
+	received := []PresenceEventType{}
+	for len(received) < 2 {
+		select {
+		case event := <-events:
+			if event.Owner == owner {
+				received = append(received, event.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Presence events not published, received: %v", received)
+		}
+	}
+	assert.Equal(t, []PresenceEventType{PresenceOnline, PresenceOffline}, received, "Published events")
+}
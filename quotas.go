@@ -0,0 +1,178 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// QuotaPeriod is a calendar period quotas are counted in
+type QuotaPeriod int
+
+const (
+	//From midnight to midnight
+	QuotaDaily QuotaPeriod = iota
+	//From first day of a month to first day of the next month
+	QuotaMonthly
+)
+
+func (period QuotaPeriod) String() string {
+	switch period {
+	case QuotaDaily:
+		return "daily"
+	case QuotaMonthly:
+		return "monthly"
+	default:
+		return fmt.Sprintf("QuotaPeriod(%d)", int(period))
+	}
+}
+
+// Returns start and end of the period of t in the location of t
+func (period QuotaPeriod) bounds(t time.Time) (time.Time, time.Time) {
+	year, month, day := t.Date()
+	if period == QuotaMonthly {
+		start := time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 0, 1)
+}
+
+// Quota caps calls of an owner in a calendar period, calls are weighted by their costs
+type Quota struct {
+	Period QuotaPeriod
+	Limit  int64
+}
+
+// QuotaPolicy sets long-term quotas of every owner
+type QuotaPolicy struct {
+	Quotas []Quota
+
+	//Time zone periods are aligned to, default is UTC
+	Location *time.Location
+}
+
+// QuotaError is returned for calls of an owner exceeding a quota, it matches ErrQuotaExceeded with errors.Is
+type QuotaError struct {
+	Quota Quota
+
+	//Start of next period, calls are allowed again
+	Resets time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s limit %d until %s", ErrQuotaExceeded.Error(), e.Quota.Period, e.Quota.Limit, e.Resets.Format(time.RFC3339))
+}
+
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
+
+// QuotaUsage is usage of a quota in its current period
+type QuotaUsage struct {
+	Quota Quota
+	Used  int64
+	Start time.Time
+	End   time.Time
+}
+
+// Remaining returns calls left in the period
+func (usage QuotaUsage) Remaining() int64 {
+	return max(usage.Quota.Limit-usage.Used, 0)
+}
+
+// SetQuotas makes RecordAPICall count calls of owners in calendar periods and reject them with
+// QuotaError once a quota is used up. Quotas are kept in redis by owner, so restarting sessions
+// doesn't reset them. They are checked and incremented atomically with scope limits, see SetScopeLimits.
+// A period can have one quota only, counters are kept by period.
+// Should be called before the manager is in use.
+func (sm *RedisSessionManager) SetQuotas(policy QuotaPolicy) error {
+	periods := make(map[QuotaPeriod]bool, len(policy.Quotas))
+	for _, quota := range policy.Quotas {
+		if quota.Period != QuotaDaily && quota.Period != QuotaMonthly {
+			return fmt.Errorf("unknown quota period %v", quota.Period)
+		}
+		if periods[quota.Period] {
+			return fmt.Errorf("duplicate %v quota", quota.Period)
+		}
+		periods[quota.Period] = true
+	}
+	if policy.Location == nil {
+		policy.Location = time.UTC
+	}
+	sm.quotaPolicy = &policy
+	return nil
+}
+
+// Returns redis key of quota counter of owner in the period starting at start, it doesn't start with session prefix
+func (sm *RedisSessionManager) getQuotaKey(owner string, period QuotaPeriod, start time.Time) string {
+	return fmt.Sprintf("quota:%s:%s:%s:%s", sm.sessionKeyPrefix, period, start.Format("2006-01-02"), owner)
+}
+
+// Returns quota counters of owner in periods of now
+func (sm *RedisSessionManager) getQuotaCounters(owner string, now time.Time) []callCounter {
+	if sm.quotaPolicy == nil {
+		return nil
+	}
+	counters := make([]callCounter, 0, len(sm.quotaPolicy.Quotas))
+	for _, quota := range sm.quotaPolicy.Quotas {
+		start, end := quota.Period.bounds(now.In(sm.quotaPolicy.Location))
+		counters = append(counters, callCounter{
+			key:     sm.getQuotaKey(owner, quota.Period, start),
+			max:     quota.Limit,
+			expires: end.UnixMilli(),
+			err:     &QuotaError{Quota: quota, Resets: end},
+		})
+	}
+	return counters
+}
+
+// GetQuotaUsage returns usage of quotas of an owner in current periods
+func (sm *RedisSessionManager) GetQuotaUsage(ctx context.Context, owner string) ([]QuotaUsage, error) {
+	if sm.quotaPolicy == nil {
+		return nil, fmt.Errorf("quotas are disabled")
+	}
+	now := time.Now().In(sm.quotaPolicy.Location)
+	usages := make([]QuotaUsage, len(sm.quotaPolicy.Quotas))
+	cmds := make([]*redis.StringCmd, len(sm.quotaPolicy.Quotas))
+	_, errExec := sm.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, quota := range sm.quotaPolicy.Quotas {
+			start, end := quota.Period.bounds(now)
+			usages[i] = QuotaUsage{Quota: quota, Start: start, End: end}
+			cmds[i] = pipe.Get(ctx, sm.getQuotaKey(owner, quota.Period, start))
+		}
+		return nil
+	})
+	if errExec != nil && !errors.Is(errExec, redis.Nil) {
+		sm.logRedisError(ctx, "GetQuotaUsage", owner, errExec)
+		return nil, errExec
+	}
+	for i, cmd := range cmds {
+		used, errGet := cmd.Int64()
+		if errGet != nil && !errors.Is(errGet, redis.Nil) {
+			sm.logRedisError(ctx, "GetQuotaUsage", owner, errGet)
+			return nil, errGet
+		}
+		usages[i].Used = used
+	}
+	return usages, nil
+}
+
+// ResetQuotas clears usage of quotas of an owner in current periods
+func (sm *RedisSessionManager) ResetQuotas(ctx context.Context, owner string) error {
+	counters := sm.getQuotaCounters(owner, time.Now())
+	if len(counters) == 0 {
+		return nil
+	}
+	keys := make([]string, len(counters))
+	for i, counter := range counters {
+		keys[i] = counter.key
+	}
+	errDel := sm.redisClient.Del(ctx, keys...).Err()
+	if errDel != nil {
+		sm.logRedisError(ctx, "ResetQuotas", owner, errDel)
+		return errDel
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestQuotas_SurviveSessionRestart$ github.com/zeroboo/go-api-session -v
+func TestQuotas_SurviveSessionRestart(t *testing.T) {
+	owner := "user_" + t.Name()
+	//Quota counters outlive sessions, a prefix per run keeps runs apart
+	prefix := fmt.Sprintf("%s_%d", sessionPrefix, time.Now().UnixNano())
+	manager := NewRedisSessionManager(redisClient, prefix, 10000, 3600000, 100, 0, false)
+	manager.SetURLCosts(map[string]int64{"/export": 5})
+	errSet := manager.SetQuotas(QuotaPolicy{
+		Quotas: []Quota{
+			{Period: QuotaDaily, Limit: 10},
+			{Period: QuotaMonthly, Limit: 100},
+		},
+	})
+	assert.Nil(t, errSet, "Set quotas, no error")
+	defer manager.ResetQuotas(context.TODO(), owner)
+	defer manager.DeleteSession(context.TODO(), owner)
+
+	sessionId, _ := manager.StartSession(context.TODO(), owner)
+	_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "/export")
+	assert.Nil(t, errRecord, "Call within quota allowed")
+
+	//Restarting session doesn't reset quotas
+	manager.DeleteSession(context.TODO(), owner)
+	sessionId, _ = manager.StartSession(context.TODO(), owner)
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "/export")
+	assert.Nil(t, errRecord, "Last call within quota allowed")
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "/read")
+	assert.True(t, errors.Is(errRecord, ErrQuotaExceeded), "Call over daily quota rejected")
+	var quotaError *QuotaError
+	assert.True(t, errors.As(errRecord, &quotaError), "Quota reported")
+	assert.Equal(t, QuotaDaily, quotaError.Quota.Period, "Daily quota exceeded")
+	_, end := QuotaDaily.bounds(time.Now().UTC())
+	assert.Equal(t, end, quotaError.Resets, "Quota resets at midnight")
+
+	usages, errUsage := manager.GetQuotaUsage(context.TODO(), owner)
+	assert.Nil(t, errUsage, "Get usage, no error")
+	assert.Equal(t, 2, len(usages), "Usage of every quota")
+	assert.Equal(t, int64(10), usages[0].Used, "Daily usage")
+	assert.Equal(t, int64(0), usages[0].Remaining(), "Daily remaining")
+	assert.Equal(t, int64(10), usages[1].Used, "Rejected call not counted")
+	assert.Equal(t, int64(90), usages[1].Remaining(), "Monthly remaining")
+
+	errReset := manager.ResetQuotas(context.TODO(), owner)
+	assert.Nil(t, errReset, "Reset quotas, no error")
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "/read")
+	assert.Nil(t, errRecord, "Call allowed after reset")
+}
+
+// go test -timeout 30s -run ^TestQuotas_ReleasedWithScopes$ github.com/zeroboo/go-api-session -v
+func TestQuotas_ReleasedWithScopes(t *testing.T) {
+	owner := "user_" + t.Name()
+	prefix := fmt.Sprintf("%s_%d", sessionPrefix, time.Now().UnixNano())
+	manager := NewRedisSessionManager(redisClient, prefix, 10000, 3600000, 100, 0, false)
+	manager.SetScopeLimits(ScopePolicy{
+		Global: &ScopeLimit{MaxCallPerWindow: 2},
+	})
+	errSet := manager.SetQuotas(QuotaPolicy{
+		Quotas: []Quota{{Period: QuotaDaily, Limit: 10}},
+	})
+	assert.Nil(t, errSet, "Set quotas, no error")
+	defer manager.ResetQuotas(context.TODO(), owner)
+	defer manager.DeleteSession(context.TODO(), owner)
+	sessionId, _ := manager.StartSession(context.TODO(), owner)
+
+	for i := 0; i < 2; i++ {
+		_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+		assert.Nil(t, errRecord, "Call allowed")
+	}
+	_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	assert.True(t, errors.Is(errRecord, ErrTooMany), "Call over global limit rejected")
+
+	usages, _ := manager.GetQuotaUsage(context.TODO(), owner)
+	assert.Equal(t, int64(2), usages[0].Used, "Call rejected by scope not counted in quota")
+}
+
+// go test -timeout 30s -run ^TestQuotaPeriod_Bounds$ github.com/zeroboo/go-api-session -v
+func TestQuotaPeriod_Bounds(t *testing.T) {
+	zone := time.FixedZone("UTC+7", 7*3600)
+	//Still 31 December in UTC
+	now := time.Date(2025, 12, 31, 20, 30, 0, 0, time.UTC).In(zone)
+
+	start, end := QuotaDaily.bounds(now)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, zone), start, "Day starts at local midnight")
+	assert.Equal(t, time.Date(2026, 1, 2, 0, 0, 0, 0, zone), end, "Day ends at next local midnight")
+
+	start, end = QuotaMonthly.bounds(now)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, zone), start, "Month starts on first day")
+	assert.Equal(t, time.Date(2026, 2, 1, 0, 0, 0, 0, zone), end, "Month ends on first day of next month")
+
+	start, _ = QuotaMonthly.bounds(time.Date(2025, 12, 31, 20, 30, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC), start, "Month in UTC")
+}
+
+// go test -timeout 30s -run ^TestSetQuotas_DuplicatePeriodRejected$ github.com/zeroboo/go-api-session -v
+func TestSetQuotas_DuplicatePeriodRejected(t *testing.T) {
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 3600000, 100, 0, false)
+	errSet := manager.SetQuotas(QuotaPolicy{
+		Quotas: []Quota{
+			{Period: QuotaDaily, Limit: 10},
+			{Period: QuotaDaily, Limit: 100},
+		},
+	})
+	assert.NotNil(t, errSet, "Duplicate period, error")
+	assert.Nil(t, manager.quotaPolicy, "Quotas not set")
+}
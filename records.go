@@ -0,0 +1,115 @@
+package apisession
+
+import (
+	"strings"
+)
+
+// Maps an api url to the url its calls are counted on
+type URLNormalizer func(url string) string
+
+// Replaces numeric and UUID path segments in NormalizeURL
+const URLIdSegment = ":id"
+
+// NormalizeURL strips query and fragment, and collapses numeric and UUID path segments,
+// eg: `/users/123/orders?page=2` becomes `/users/:id/orders`
+func NormalizeURL(url string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	segments := strings.Split(url, "/")
+	for i, segment := range segments {
+		if isNumericSegment(segment) || isUUIDSegment(segment) {
+			segments[i] = URLIdSegment
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isNumericSegment(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	for _, c := range segment {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isUUIDSegment(segment string) bool {
+	if len(segment) != 36 {
+		return false
+	}
+	for i, c := range segment {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			isHex := (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+			if !isHex {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetURLNormalizer sets normalizer applied to urls before counting calls, eg: NormalizeURL.
+// Default is nil: every distinct url is counted separately.
+func (sm *RedisSessionManager) SetURLNormalizer(normalizer URLNormalizer) {
+	sm.urlNormalizer = normalizer
+}
+
+// SetMaxRecords caps number of urls tracked in a session, 0 means no limit.
+// When a new url is called on a full session, records idle for more than a window are evicted.
+// If none is idle the call is rejected with ErrTooManyRecords, so counters of urls in use are never
+// dropped. The cap should be above the number of distinct normalized urls a user calls in a window.
+func (sm *RedisSessionManager) SetMaxRecords(maxRecords int) {
+	sm.maxRecords = maxRecords
+}
+
+// Returns url calls of request are counted on
+func (sm *RedisSessionManager) normalizeURL(url string) string {
+	if sm.urlNormalizer == nil {
+		return url
+	}
+	return sm.urlNormalizer(url)
+}
+
+// Makes room for a new record of url if session is full.
+// Returns ErrTooManyRecords if session is full of records in use.
+func (sm *RedisSessionManager) makeRoomForRecord(session *APISession, url string, now int64) error {
+	if sm.maxRecords <= 0 {
+		return nil
+	}
+	if _, exist := session.Records[url]; exist {
+		return nil
+	}
+	session.EvictRecords(sm.maxRecords-1, now-sm.windowSize)
+	if len(session.Records) >= sm.maxRecords {
+		return ErrTooManyRecords
+	}
+	return nil
+}
+
+// EvictRecords removes records last called before idleBefore (milliseconds) if there are more
+// than maxRecords records. Records called since idleBefore are kept, so more than maxRecords may be left.
+//
+// Returns:
+//   - urls []string: urls of evicted records
+func (ses *APISession) EvictRecords(maxRecords int, idleBefore int64) []string {
+	if len(ses.Records) <= maxRecords {
+		return nil
+	}
+	evicted := []string{}
+	for url, record := range ses.Records {
+		if record.Last < idleBefore {
+			delete(ses.Records, url)
+			evicted = append(evicted, url)
+		}
+	}
+	return evicted
+}
@@ -0,0 +1,93 @@
+package apisession
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestNormalizeURL_Correct$ github.com/zeroboo/go-api-session -v
+func TestNormalizeURL_Correct(t *testing.T) {
+	assert.Equal(t, "/users/:id/orders", NormalizeURL("/users/123/orders?page=2"), "Numeric segment and query")
+	assert.Equal(t, "/files/:id", NormalizeURL("/files/3F2504E0-4F89-11D3-9A0C-0305E82C3301#top"), "UUID segment and fragment")
+	assert.Equal(t, "/users/me/v2", NormalizeURL("/users/me/v2"), "Other segments kept")
+	assert.Equal(t, "url1", NormalizeURL("url1"), "Plain url kept")
+}
+
+// go test -timeout 30s -run ^TestMaxRecords_SessionSizeBounded$ github.com/zeroboo/go-api-session -v
+func TestMaxRecords_SessionSizeBounded(t *testing.T) {
+	for _, layout := range []StorageLayout{LayoutBlob, LayoutHash} {
+		owner := fmt.Sprintf("user_%v_%v", t.Name(), layout)
+		manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 200, 10, 0, false)
+		manager.SetStorageLayout(layout)
+		manager.SetURLNormalizer(NormalizeURL)
+		manager.SetMaxRecords(3)
+		sessionId, _ := manager.StartSession(context.TODO(), owner)
+		sessionOwners = append(sessionOwners, owner)
+
+		for i := 0; i < 5; i++ {
+			_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, fmt.Sprintf("/items/%d?v=%d", i, i))
+			assert.Nil(t, errRecord, "Same normalized url, no error: %v", layout)
+		}
+		for i := 0; i < 2; i++ {
+			_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, fmt.Sprintf("/url%d", i))
+			assert.Nil(t, errRecord, "New url, no error: %v", layout)
+		}
+		_, errFull := manager.RecordAPICall(context.TODO(), sessionId, owner, "/url2")
+		assert.Equal(t, ErrTooManyRecords, errFull, "No idle record, rejected: %v", layout)
+
+		time.Sleep(250 * time.Millisecond)
+		_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "/url2")
+		assert.Nil(t, errRecord, "Idle records evicted, no error: %v", layout)
+
+		session, _ := manager.GetSession(context.TODO(), owner)
+		assert.Equal(t, 1, len(session.Records), "Idle records evicted: %v", layout)
+		assert.Contains(t, session.Records, "/url2", "Latest url kept: %v", layout)
+	}
+}
+
+// go test -timeout 30s -run ^TestMaxRecords_ThrottledURLKept$ github.com/zeroboo/go-api-session -v
+func TestMaxRecords_ThrottledURLKept(t *testing.T) {
+	for _, layout := range []StorageLayout{LayoutBlob, LayoutHash} {
+		owner := fmt.Sprintf("user_%v_%v", t.Name(), layout)
+		manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 3600000, 2, 0, false)
+		manager.SetStorageLayout(layout)
+		manager.SetMaxRecords(3)
+		sessionId, _ := manager.StartSession(context.TODO(), owner)
+		sessionOwners = append(sessionOwners, owner)
+
+		for i := 0; i < 2; i++ {
+			_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "/throttled")
+			assert.Nil(t, errRecord, "Within limit, no error: %v", layout)
+		}
+		_, errThrottled := manager.RecordAPICall(context.TODO(), sessionId, owner, "/throttled")
+		assert.Equal(t, ErrTooMany, errThrottled, "Over limit: %v", layout)
+
+		//maxRecords+1 distinct urls
+		for i := 0; i < 3; i++ {
+			manager.RecordAPICall(context.TODO(), sessionId, owner, fmt.Sprintf("/url%d", i))
+		}
+		_, errThrottled = manager.RecordAPICall(context.TODO(), sessionId, owner, "/throttled")
+		assert.Equal(t, ErrTooMany, errThrottled, "Throttled url still rejected: %v", layout)
+	}
+}
+
+// go test -timeout 30s -run ^TestEvictRecords_IdleOnly$ github.com/zeroboo/go-api-session -v
+func TestEvictRecords_IdleOnly(t *testing.T) {
+	session := NewAPISession("user1")
+	session.GetCallRecord("idle1").Last = 100
+	session.GetCallRecord("idle2").Last = 200
+	session.GetCallRecord("active1").Last = 1000
+	session.GetCallRecord("active2").Last = 2000
+
+	evicted := session.EvictRecords(3, 500)
+	assert.ElementsMatch(t, []string{"idle1", "idle2"}, evicted, "All idle records evicted")
+	assert.Equal(t, 2, len(session.Records), "Active records kept")
+
+	evicted = session.EvictRecords(1, 500)
+	assert.Empty(t, evicted, "Active records are not evicted")
+	assert.Equal(t, 2, len(session.Records), "Active records kept")
+}
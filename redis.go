@@ -3,12 +3,14 @@ package apisession
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand"
+	"sync/atomic"
 	"time"
 
 	redis "github.com/redis/go-redis/v9"
-	"github.com/vmihailenco/msgpack/v5"
 )
 
 type RedisSessionManager struct {
@@ -20,15 +22,60 @@ type RedisSessionManager struct {
 	//In milliseconds
 	windowSize int64
 
-	//Max request in a time window
+	//Max request in a time window, budget of weighted calls
 	maxCallPerWindow int64
 
+	//Default costs of normalized urls, see SetURLCosts
+	urlCosts map[string]int64
+
 	//minimum milliseconds between 2 request, 0 mean no limit
 	requestInterval int64
 
 	//Track online users
 	trackOnlineUsers bool
 	onlineUserKey    string
+
+	//Presence events, see EnablePresence
+	presenceEnabled  bool
+	presenceTimeout  int64
+	presenceListener PresenceListener
+	presencePublish  bool
+
+	//Lifecycle observers, see AddHooks
+	hooks []SessionHooks
+
+	logger *slog.Logger
+
+	//Codec of new writes, see SetCodec
+	codec Codec
+
+	layout StorageLayout
+
+	//Bounded records, see SetURLNormalizer and SetMaxRecords
+	urlNormalizer URLNormalizer
+	maxRecords    int
+
+	//Local cache of GetSessionCached, nil if disabled
+	cache *sessionCache
+
+	//If true, owners of changed sessions are published, see EnableCacheInvalidation.
+	//Atomic since admin.NewHandler may enable it on a manager in use
+	cacheInvalidation atomic.Bool
+
+	//Reject banned owners, see EnableBans
+	bansEnabled bool
+
+	//Lock owners out after repeated violations, nil if disabled, see EnablePenalties
+	penaltyPolicy *PenaltyPolicy
+
+	//Check clients of requests, nil if disabled, see SetBindingPolicy
+	bindingPolicy *BindingPolicy
+
+	//Limits above session level, nil if disabled, see SetScopeLimits
+	scopePolicy *ScopePolicy
+
+	//Long-term quotas of owners, nil if disabled, see SetQuotas
+	quotaPolicy *QuotaPolicy
 }
 
 // Create redis session manager
@@ -61,6 +108,8 @@ func NewRedisSessionManager(redisClient *redis.Client,
 		maxCallPerWindow: maxCallPerWindow,
 		requestInterval:  requestInterval,
 		trackOnlineUsers: trackOnlineUsers,
+		logger:           discardLogger,
+		codec:            MsgpackCodec{},
 	}
 
 	if trackOnlineUsers {
@@ -94,27 +143,66 @@ func GetRedisSessionKey(prefix string, sessionId string) string {
 }
 
 func (sm *RedisSessionManager) RecordAPICall(ctx context.Context, sessionValue string, owner string, url string) (*APISession, error) {
-	session, errGet := sm.GetSession(ctx, owner)
-	if errGet != nil {
-		return nil, errGet
-	}
-
-	//Validate session
-	now := time.Now()
-	errValidate := sm.ValidateAPICall(&APIRequest{
+	return sm.RecordAPIRequest(ctx, &APIRequest{
 		Owner:     owner,
 		SessionId: sessionValue,
 		URL:       url,
-	}, session, now)
+	})
+}
+
+// RecordAPIRequest records an api call like RecordAPICall, with client and other attributes of request
+func (sm *RedisSessionManager) RecordAPIRequest(ctx context.Context, request *APIRequest) (*APISession, error) {
+	owner := request.Owner
+	url := request.URL
+	//Validate and save session atomically, so concurrent updates are not overwritten
+	var errValidate error
+	//Scope and quota counters of the last validation, released if the call is not saved
+	var counterKeys []string
+	cost := sm.getCallCost(request, sm.normalizeURL(url))
+	validate := func(session *APISession) error {
+		sm.releaseCounters(ctx, owner, counterKeys, cost)
+		counterKeys = nil
+		now := time.Now()
+		errValidate = sm.ValidateAPICall(request, session, now)
+		if sm.scopePolicy != nil && (errors.Is(errValidate, ErrTooMany) || errors.Is(errValidate, ErrTooFast)) {
+			errValidate = &ScopeError{Scope: ScopeSession, Cause: errValidate}
+		}
+		if errValidate == nil {
+			counterKeys, errValidate = sm.reserveCounters(ctx, session, now.UnixMilli(), cost)
+		}
+		return errValidate
+	}
+	var session *APISession
+	var errUpdate error
+	errCheck := sm.checkOwner(ctx, owner)
+	if errors.Is(errCheck, ErrBanned) || errors.Is(errCheck, ErrLockedOut) {
+		errValidate = errCheck
+	} else if errCheck != nil {
+		return nil, errCheck
+	} else if sm.layout == LayoutHash {
+		session, errUpdate = sm.recordAPICallHash(ctx, request, validate)
+	} else {
+		session, errUpdate = sm.watchSession(ctx, owner, validate, true)
+	}
+	if errValidate != nil || errUpdate != nil {
+		sm.releaseCounters(ctx, owner, counterKeys, cost)
+	}
+	if isViolation(errValidate) {
+		errValidate = sm.recordViolation(ctx, owner, errValidate)
+	}
+	if errors.Is(errValidate, ErrReauthRequired) {
+		sm.revokeForReauth(ctx, owner)
+	}
 	if errValidate != nil {
+		sm.logger.InfoContext(ctx, "api call rejected", "owner", owner, "url", url, "reason", errValidate)
+		sm.notifyReject(ctx, request, errValidate)
 		return nil, errValidate
 	}
-
-	errUpdate := sm.SetSession(ctx, owner, session)
 	if errUpdate != nil {
 		return nil, errUpdate
 	}
 
+	sm.notifyRecord(ctx, request, session)
 	return session, nil
 }
 
@@ -122,83 +210,143 @@ type APIRequest struct {
 	Owner     string
 	SessionId string
 	URL       string
+
+	//Client making the call, nil if unknown, see SetBindingPolicy
+	Client *ClientInfo
+
+	//Budget used by the call, 0 means default cost of the url, see SetURLCosts
+	Cost int64
+}
+
+// Returns a copy of request
+func (request *APIRequest) clone() *APIRequest {
+	if request == nil {
+		return nil
+	}
+	copied := *request
+	if request.Client != nil {
+		client := *request.Client
+		copied.Client = &client
+	}
+	return &copied
 }
 
 func (sm *RedisSessionManager) ValidateAPICall(request *APIRequest, session *APISession, currentTime time.Time) error {
 	if session.Id != request.SessionId {
 		return ErrInvalidSession
 	}
+	errClient := sm.validateClient(request, session)
+	if errClient != nil {
+		return errClient
+	}
 	now := currentTime.UnixMilli()
-	err := sm.UpdateSession(now, session)
-	if err != nil {
-		return err
+	sm.UpdateWindow(now, session)
+	url := sm.normalizeURL(request.URL)
+	errRecords := sm.makeRoomForRecord(session, url, now)
+	if errRecords != nil {
+		return errRecords
 	}
-	call := session.GetCallRecord(request.URL)
+	call := session.GetCallRecord(url)
+	cost := sm.getCallCost(request, url)
+	return countCall(call, now, sm.requestInterval, sm.maxCallPerWindow, cost, func() bool {
+		return session.useGrant(url, now, cost)
+	})
+}
 
-	if sm.requestInterval > 0 {
-		if now-call.Last < sm.requestInterval {
-			return ErrTooFast
-		}
+// Counts a call of cost at now on a record of the current window if limits allow it.
+// extraCall is asked when the budget of the window is not enough, the call is allowed if it returns true. Can be nil.
+// Returns ErrTooFast or ErrTooMany if the call is rejected, record is unchanged then.
+func countCall(call *APICallRecord, now int64, requestInterval int64, maxCalls int64, cost int64, extraCall func() bool) error {
+	if requestInterval > 0 && now-call.Last < requestInterval {
+		return ErrTooFast
 	}
-	if call.Count+1 > sm.maxCallPerWindow {
+	if call.Count+cost > maxCalls && (extraCall == nil || !extraCall()) {
 		return ErrTooMany
 	}
-	call.Count++
+	call.Count += cost
 	call.Last = now
-
 	return nil
-
 }
 
 func (sm *RedisSessionManager) GetSession(ctx context.Context, owner string) (*APISession, error) {
-	key := sm.GetSessionKey(owner)
-	cmd := sm.redisClient.Get(ctx, key)
-	bytes, errRedis := cmd.Bytes()
-	if errRedis != nil {
-		return nil, errRedis
-	}
-
-	session := &APISession{}
-	errUnmarshal := msgpack.Unmarshal(bytes, session)
-
-	if errUnmarshal != nil {
-		return nil, errUnmarshal
+	session, errLoad := sm.loadSession(ctx, sm.redisClient, owner)
+	if errLoad != nil {
+		sm.logLoadError(ctx, "GetSession", owner, errLoad)
+		return nil, errLoad
 	}
-	return session, errUnmarshal
+	return session, nil
 }
 
-func (sm *RedisSessionManager) UpdateSession(currentMillis int64, session *APISession) error {
+// UpdateWindow moves session to the time window of currentMillis, counters are reset if window changes.
+// Session is not saved.
+func (sm *RedisSessionManager) UpdateWindow(currentMillis int64, session *APISession) {
 	window := currentMillis / sm.windowSize
 	if window != session.Window {
 		session.SetWindow(window)
 	}
 	session.Updated = currentMillis
-
-	return sm.SetSession(context.Background(), session.Owner, session)
 }
 
+// SetSession saves session of a user, overwriting the stored session whatever its version is.
+// Use SetSessionIfVersion or UpdateSession to not lose concurrent updates.
 func (sm *RedisSessionManager) SetSession(ctx context.Context, owner string, session *APISession) error {
-	session.Updated = time.Now().UnixMilli()
-	payload, errSerialize := msgpack.Marshal(session)
-	if errSerialize != nil {
-		return errSerialize
+	errSave := sm.saveSession(ctx, nil, owner, session, true)
+	if errSave != nil {
+		return errSave
 	}
+	sm.invalidateSession(ctx, owner)
+	return nil
+}
 
+// Saves session with next version in a transaction, executed on tx if not nil.
+//
+// If touch is true, session TTL is renewed and owner is marked active in online users,
+// otherwise TTL is kept.
+func (sm *RedisSessionManager) saveSession(ctx context.Context, tx *redis.Tx, owner string, session *APISession, touch bool) error {
 	key := sm.GetSessionKey(owner)
-	cmd := sm.redisClient.Set(ctx, key, payload, sm.sessionTTL)
-	if cmd.Err() != nil {
-		return cmd.Err()
+	ttl := sm.sessionTTL
+	if !touch {
+		ttl = -1
+		if sm.layout == LayoutHash && tx != nil {
+			//Hash is rewritten, so its TTL is read to be set again
+			ttl = tx.PTTL(ctx, key).Val()
+		}
 	}
 
-	if sm.trackOnlineUsers {
-		// Update online user tracking
-		cmd := sm.redisClient.ZAdd(context.Background(), sm.onlineUserKey, redis.Z{
-			Score:  float64(session.Updated),
-			Member: session.Owner,
-		})
-		if cmd.Err() != nil {
-			return cmd.Err()
+	session.Updated = time.Now().UnixMilli()
+	session.Version++
+	var onlineCmd *redis.IntCmd
+	queue := func(pipe redis.Pipeliner) error {
+		errQueue := sm.queueWriteSession(ctx, pipe, key, session, ttl)
+		if errQueue != nil {
+			return errQueue
 		}
+		if touch && sm.trackOnlineUsers {
+			// Update online user tracking
+			onlineCmd = pipe.ZAdd(ctx, sm.onlineUserKey, redis.Z{
+				Score:  float64(session.Updated),
+				Member: session.Owner,
+			})
+		}
+		return nil
+	}
+
+	var errExec error
+	if tx != nil {
+		_, errExec = tx.TxPipelined(ctx, queue)
+	} else {
+		_, errExec = sm.redisClient.TxPipelined(ctx, queue)
+	}
+	if errExec != nil {
+		session.Version--
+		if !errors.Is(errExec, redis.TxFailedErr) {
+			sm.logRedisError(ctx, "SetSession", owner, errExec)
+		}
+		return errExec
+	}
+
+	if onlineCmd != nil && onlineCmd.Val() > 0 {
+		sm.emitPresence(ctx, session.Owner, PresenceOnline, session.Updated)
 	}
 	return nil
 }
@@ -210,36 +358,58 @@ func (sm *RedisSessionManager) SetSession(ctx context.Context, owner string, ses
 //   - error: error if exists, nil is successful
 func (sm *RedisSessionManager) StartSession(ctx context.Context, owner string) (string, error) {
 	session := NewAPISession(owner)
-	errSet := sm.SetSession(ctx, owner, session)
-	if errSet != nil {
-		return "", errSet
+	errStart := sm.startSession(ctx, owner, session)
+	if errStart != nil {
+		return "", errStart
 	}
 	return session.Id, nil
 }
 
 func (sm *RedisSessionManager) StartSessionWithPayload(ctx context.Context, owner string, payload map[string]any) (*APISession, error) {
 	session := NewAPISessionWithPayload(owner, payload)
+	errStart := sm.startSession(ctx, owner, session)
+	if errStart != nil {
+		return nil, errStart
+	}
+	return session, nil
+}
+
+// Saves a new session of owner if owner is not banned, then notifies hooks
+func (sm *RedisSessionManager) startSession(ctx context.Context, owner string, session *APISession) error {
+	errBan := sm.checkBan(ctx, owner)
+	if errBan != nil {
+		return errBan
+	}
+	session.codec = sm.codec
 	errSet := sm.SetSession(ctx, owner, session)
 	if errSet != nil {
-		return nil, errSet
+		return errSet
 	}
-	return session, nil
+	sm.notifyStart(ctx, session)
+	return nil
 }
 
 func (sm *RedisSessionManager) DeleteSession(ctx context.Context, owner string) error {
 	key := sm.GetSessionKey(owner)
 	cmd := sm.redisClient.Del(ctx, key)
 	if cmd.Err() != nil {
+		sm.logRedisError(ctx, "DeleteSession", owner, cmd.Err())
 		return cmd.Err()
 	}
+	sm.invalidateSession(ctx, owner)
 
 	if sm.trackOnlineUsers {
 		// Remove from online users tracking
 		cmd := sm.redisClient.ZRem(ctx, sm.onlineUserKey, owner)
 		if cmd.Err() != nil {
+			sm.logRedisError(ctx, "DeleteSession", owner, cmd.Err())
 			return cmd.Err()
 		}
+		if cmd.Val() > 0 {
+			sm.emitPresence(ctx, owner, PresenceOffline, time.Now().UnixMilli())
+		}
 	}
+	sm.notifyDelete(ctx, owner)
 	return nil
 }
 
@@ -262,6 +432,7 @@ func (sm *RedisSessionManager) GetOnlineUsers(ctx context.Context) (map[string]i
 
 	cmd := sm.redisClient.ZRangeWithScores(ctx, sm.onlineUserKey, 0, -1)
 	if cmd.Err() != nil {
+		sm.logRedisError(ctx, "GetOnlineUsers", "", cmd.Err())
 		return nil, cmd.Err()
 	}
 	onlineUsers := make(map[string]int64)
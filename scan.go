@@ -0,0 +1,152 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Default keys per SCAN call of ScanSessions
+const defaultScanCount = 100
+
+// SessionFilter selects sessions of ScanSessions, zero values match all sessions
+type SessionFilter struct {
+	//Glob pattern of owners like `user_*`, see redis SCAN MATCH
+	OwnerPattern string
+
+	//Range of Created in milliseconds, inclusive, 0 means unbounded
+	CreatedFrom int64
+	CreatedTo   int64
+
+	//Range of Updated in milliseconds, inclusive, 0 means unbounded
+	UpdatedFrom int64
+	UpdatedTo   int64
+
+	//Payload predicate, called with decoded payload which may be nil
+	Payload func(payload map[string]any) bool
+
+	//Keys per SCAN call, default is 100
+	Count int64
+}
+
+func (f *SessionFilter) match(session *APISession) bool {
+	if (f.CreatedFrom > 0 && session.Created < f.CreatedFrom) || (f.CreatedTo > 0 && session.Created > f.CreatedTo) {
+		return false
+	}
+	if (f.UpdatedFrom > 0 && session.Updated < f.UpdatedFrom) || (f.UpdatedTo > 0 && session.Updated > f.UpdatedTo) {
+		return false
+	}
+	return f.Payload == nil || f.Payload(session.Payload)
+}
+
+// SessionIterator iterates sessions found by ScanSessions:
+//
+//	iter := manager.ScanSessions(filter)
+//	for iter.Next(ctx) {
+//		session := iter.Session()
+//	}
+//	if err := iter.Err(); err != nil {
+//	}
+type SessionIterator struct {
+	sm      *RedisSessionManager
+	filter  SessionFilter
+	match   string
+	cursor  uint64
+	done    bool
+	pending []*APISession
+	session *APISession
+	err     error
+}
+
+// ScanSessions returns an iterator over sessions of the manager prefix matching filter.
+//
+// Keys are read with SCAN and sessions are loaded in pipelines of filter.Count,
+// so redis is not blocked by large key spaces. Like SCAN, a session may be returned more than once,
+// and sessions changed during the iteration may be missed. Sessions that can't be decoded are skipped.
+func (sm *RedisSessionManager) ScanSessions(filter SessionFilter) *SessionIterator {
+	ownerPattern := filter.OwnerPattern
+	if ownerPattern == "" {
+		ownerPattern = "*"
+	}
+	if filter.Count <= 0 {
+		filter.Count = defaultScanCount
+	}
+	return &SessionIterator{
+		sm:     sm,
+		filter: filter,
+		match:  GetRedisSessionKey(escapeGlob(sm.sessionKeyPrefix), ownerPattern),
+	}
+}
+
+// Escapes glob special characters of s
+func escapeGlob(s string) string {
+	var builder strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(c)
+	}
+	return builder.String()
+}
+
+// Next moves to next matching session, returns false when iteration ends or fails
+func (it *SessionIterator) Next(ctx context.Context) bool {
+	for len(it.pending) == 0 {
+		if it.done || it.err != nil {
+			it.session = nil
+			return false
+		}
+		it.err = it.scan(ctx)
+	}
+	it.session = it.pending[0]
+	it.pending = it.pending[1:]
+	return true
+}
+
+// Loads sessions of next SCAN page
+func (it *SessionIterator) scan(ctx context.Context) error {
+	keys, cursor, errScan := it.sm.redisClient.Scan(ctx, it.cursor, it.match, it.filter.Count).Result()
+	if errScan != nil {
+		it.sm.logRedisError(ctx, "ScanSessions", "", errScan)
+		return errScan
+	}
+	it.cursor = cursor
+	it.done = cursor == 0
+	if len(keys) == 0 {
+		return nil
+	}
+
+	keyPrefix := GetRedisSessionKey(it.sm.sessionKeyPrefix, "")
+	owners := make([]string, len(keys))
+	for i, key := range keys {
+		owners[i] = strings.TrimPrefix(key, keyPrefix)
+	}
+	for _, result := range it.sm.GetSessions(ctx, owners) {
+		var errDecode *decodeError
+		switch {
+		case result.Err == nil:
+			if it.filter.match(result.Session) {
+				it.pending = append(it.pending, result.Session)
+			}
+		case result.Err == redis.Nil, errors.As(result.Err, &errDecode):
+			//Expired after SCAN or not a session
+		default:
+			return result.Err
+		}
+	}
+	return nil
+}
+
+// Session returns current session of the iteration
+func (it *SessionIterator) Session() *APISession {
+	return it.session
+}
+
+// Err returns error stopping the iteration, nil if iteration ended normally
+func (it *SessionIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,58 @@
+package apisession
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestScanSessions_Filter_Correct$ github.com/zeroboo/go-api-session -v
+func TestScanSessions_Filter_Correct(t *testing.T) {
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	hashManager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	hashManager.SetStorageLayout(LayoutHash)
+	for i := 0; i < 10; i++ {
+		owner := fmt.Sprintf("user_%s_%d", t.Name(), i)
+		starter := manager
+		if i%2 == 1 {
+			starter = hashManager
+		}
+		starter.StartSessionWithPayload(context.TODO(), owner, map[string]any{"level": i})
+		sessionOwners = append(sessionOwners, owner)
+	}
+
+	scanOwners := func(filter SessionFilter) map[string]bool {
+		owners := make(map[string]bool)
+		iter := manager.ScanSessions(filter)
+		for iter.Next(context.TODO()) {
+			owners[iter.Session().Owner] = true
+		}
+		assert.Nil(t, iter.Err(), "Scan, no error")
+		return owners
+	}
+
+	owners := scanOwners(SessionFilter{OwnerPattern: "user_" + t.Name() + "_*", Count: 3})
+	assert.Equal(t, 10, len(owners), "All sessions of both layouts")
+
+	owners = scanOwners(SessionFilter{
+		OwnerPattern: "user_" + t.Name() + "_*",
+		Payload: func(payload map[string]any) bool {
+			level, _ := toInt64(payload["level"])
+			return level >= 7
+		},
+	})
+	assert.Equal(t, map[string]bool{
+		"user_" + t.Name() + "_7": true,
+		"user_" + t.Name() + "_8": true,
+		"user_" + t.Name() + "_9": true,
+	}, owners, "Filtered by payload")
+
+	owners = scanOwners(SessionFilter{
+		OwnerPattern: "user_" + t.Name() + "_*",
+		CreatedFrom:  time.Now().Add(time.Hour).UnixMilli(),
+	})
+	assert.Equal(t, 0, len(owners), "Filtered by created")
+}
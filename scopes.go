@@ -0,0 +1,255 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Scopes of limits, reported in ScopeError
+const (
+	ScopeSession      = "session"
+	ScopeOwner        = "owner"
+	ScopeOrganization = "organization"
+	ScopeGlobal       = "global"
+)
+
+// ScopeLimit caps calls of a scope per time window, calls to all urls are counted together
+type ScopeLimit struct {
+	//In milliseconds, 0 means window size of the manager
+	WindowSize int64
+
+	//Budget of weighted calls, see SetURLCosts
+	MaxCallPerWindow int64
+}
+
+// ScopePolicy sets limits above the session level, nil limits are not checked.
+// Owner limits are kept when an owner restarts a session.
+type ScopePolicy struct {
+	Owner        *ScopeLimit
+	Organization *ScopeLimit
+	Global       *ScopeLimit
+
+	//Payload key of organization id, sessions without it are not limited by organization.
+	//Integer ids are counted alike whatever codec decoded them, eg: 7 and 7.0
+	OrganizationKey string
+}
+
+// ScopeError is returned for calls rejected by a limit scope, it matches its cause with errors.Is
+type ScopeError struct {
+	//Scope tripped, one of Scope constants
+	Scope string
+
+	//Owner or organization id of the scope, empty for session and global scopes
+	Key string
+
+	//ErrTooMany, or ErrTooFast for session scope
+	Cause error
+}
+
+func (e *ScopeError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s in %s scope", e.Cause.Error(), e.Scope)
+	}
+	return fmt.Sprintf("%s in %s scope %s", e.Cause.Error(), e.Scope, e.Key)
+}
+
+func (e *ScopeError) Unwrap() error { return e.Cause }
+
+// Checks counters of a call, adds cost of the call (last argument) to them only if none is exhausted.
+// Returns 0 if call is counted, otherwise 1-based index of the exhausted counter.
+var reserveCountersScript = redis.NewScript(`
+local cost = tonumber(ARGV[#ARGV])
+for i, key in ipairs(KEYS) do
+	local count = tonumber(redis.call("GET", key) or "0")
+	if count + cost > tonumber(ARGV[i]) then
+		return i
+	end
+end
+for i, key in ipairs(KEYS) do
+	redis.call("INCRBY", key, cost)
+	redis.call("PEXPIREAT", key, ARGV[#KEYS + i])
+end
+return 0
+`)
+
+// Takes back cost of a call counted by reserveCountersScript, counters of ended periods are not recreated
+var releaseCountersScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if redis.call("EXISTS", key) == 1 then
+		redis.call("DECRBY", key, ARGV[1])
+	end
+end
+return 0
+`)
+
+// SetScopeLimits makes RecordAPICall check limits of owner, organization and whole service
+// besides session limits. Counters of all levels are checked and incremented atomically,
+// a call rejected by any level is counted by none. Rejections of all levels are returned
+// as ScopeError, only session and owner rejections count as violations of EnablePenalties.
+// Checking scopes costs one more redis call per api call.
+//
+// Counters are reserved before the session is saved and released if saving fails. If the process dies
+// in between, the reserved calls stay counted until their window ends.
+// Should be called before the manager is in use.
+func (sm *RedisSessionManager) SetScopeLimits(policy ScopePolicy) {
+	sm.scopePolicy = &policy
+}
+
+// A counter of a call, reserved with other counters of the call by reserveCounters
+type callCounter struct {
+	key string
+	max int64
+
+	//Expired time in milliseconds
+	expires int64
+
+	//Returned when the counter is exhausted
+	err error
+}
+
+// Returns counters of scopes limiting a call of session at now
+func (sm *RedisSessionManager) getScopeCounters(session *APISession, now int64) []callCounter {
+	policy := sm.scopePolicy
+	counters := []callCounter{}
+	if policy == nil {
+		return counters
+	}
+	add := func(scope string, key string, limit *ScopeLimit) {
+		windowSize := sm.getScopeWindowSize(limit)
+		window := now / windowSize
+		counters = append(counters, callCounter{
+			key:     sm.getScopeKey(scope, key, window),
+			max:     limit.MaxCallPerWindow,
+			expires: (window + 1) * windowSize,
+			err:     &ScopeError{Scope: scope, Key: key, Cause: ErrTooMany},
+		})
+	}
+	if policy.Owner != nil {
+		add(ScopeOwner, session.Owner, policy.Owner)
+	}
+	if policy.Organization != nil && policy.OrganizationKey != "" {
+		if organization := session.GetPayload(policy.OrganizationKey); organization != nil {
+			add(ScopeOrganization, getOrganizationKey(organization), policy.Organization)
+		}
+	}
+	if policy.Global != nil {
+		add(ScopeGlobal, "", policy.Global)
+	}
+	return counters
+}
+
+// Returns organization id of a payload value, integers are formatted alike whatever their decoded type
+func getOrganizationKey(organization any) string {
+	if key, ok := organization.(string); ok {
+		return key
+	}
+	if number, ok := toInt64(organization); ok {
+		return strconv.FormatInt(number, 10)
+	}
+	return fmt.Sprint(organization)
+}
+
+func (sm *RedisSessionManager) getScopeWindowSize(limit *ScopeLimit) int64 {
+	if limit.WindowSize <= 0 {
+		return sm.windowSize
+	}
+	return limit.WindowSize
+}
+
+// Returns redis key of a scope counter in window, it doesn't start with session prefix
+func (sm *RedisSessionManager) getScopeKey(scope string, key string, window int64) string {
+	if key == "" {
+		return fmt.Sprintf("scope:%s:%s:%d", sm.sessionKeyPrefix, scope, window)
+	}
+	return fmt.Sprintf("scope:%s:%s:%s:%d", sm.sessionKeyPrefix, scope, key, window)
+}
+
+// Counts cost of a call of session in scopes and quotas if none is exhausted.
+// Counters are not in the session transaction: callers must release them if the session is not saved,
+// they leak until the end of their window or period if the process dies before.
+//
+// Returns:
+//   - keys: counters incremented, to be released if the call is not saved
+//   - error: ScopeError or QuotaError of the first exhausted counter
+func (sm *RedisSessionManager) reserveCounters(ctx context.Context, session *APISession, now int64, cost int64) ([]string, error) {
+	counters := append(sm.getScopeCounters(session, now), sm.getQuotaCounters(session.Owner, time.UnixMilli(now))...)
+	if len(counters) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(counters))
+	args := make([]any, 2*len(counters)+1)
+	for i, counter := range counters {
+		keys[i] = counter.key
+		args[i] = counter.max
+		args[len(counters)+i] = counter.expires
+	}
+	args[len(args)-1] = cost
+
+	exhausted, errScript := reserveCountersScript.Run(ctx, sm.redisClient, keys, args...).Int()
+	if errScript != nil {
+		sm.logRedisError(ctx, "ReserveCounters", session.Owner, errScript)
+		return nil, errScript
+	}
+	if exhausted > 0 {
+		return nil, counters[exhausted-1].err
+	}
+	return keys, nil
+}
+
+// Releases counters reserved for a call which is not saved
+func (sm *RedisSessionManager) releaseCounters(ctx context.Context, owner string, keys []string, cost int64) {
+	if len(keys) == 0 {
+		return
+	}
+	errScript := releaseCountersScript.Run(ctx, sm.redisClient, keys, cost).Err()
+	if errScript != nil {
+		sm.logRedisError(ctx, "ReleaseCounters", owner, errScript)
+	}
+}
+
+// GetScopeCalls returns cost of calls counted in current window of a scope.
+//
+// Params:
+//   - scope: ScopeOwner, ScopeOrganization or ScopeGlobal
+//   - key: owner or organization id, empty for global scope
+func (sm *RedisSessionManager) GetScopeCalls(ctx context.Context, scope string, key string) (int64, error) {
+	if sm.scopePolicy == nil {
+		return 0, fmt.Errorf("scope limits are disabled")
+	}
+	var limit *ScopeLimit
+	switch scope {
+	case ScopeOwner:
+		limit = sm.scopePolicy.Owner
+	case ScopeOrganization:
+		limit = sm.scopePolicy.Organization
+	case ScopeGlobal:
+		limit = sm.scopePolicy.Global
+	}
+	if limit == nil {
+		return 0, fmt.Errorf("scope %s is not limited", scope)
+	}
+	window := time.Now().UnixMilli() / sm.getScopeWindowSize(limit)
+	calls, errGet := sm.redisClient.Get(ctx, sm.getScopeKey(scope, key, window)).Int64()
+	if errors.Is(errGet, redis.Nil) {
+		return 0, nil
+	}
+	if errGet != nil {
+		sm.logRedisError(ctx, "GetScopeCalls", key, errGet)
+		return 0, errGet
+	}
+	return calls, nil
+}
+
+// Returns true if a rejection counts as violation of the owner
+func isViolation(err error) bool {
+	var scopeError *ScopeError
+	if errors.As(err, &scopeError) {
+		return scopeError.Scope == ScopeSession || scopeError.Scope == ScopeOwner
+	}
+	return errors.Is(err, ErrTooMany) || errors.Is(err, ErrTooFast)
+}
@@ -0,0 +1,133 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestScopes_OwnerLimitSurvivesRestart$ github.com/zeroboo/go-api-session -v
+func TestScopes_OwnerLimitSurvivesRestart(t *testing.T) {
+	owner := "user_" + t.Name()
+	//Scope counters outlive sessions, a prefix per run keeps runs apart
+	prefix := fmt.Sprintf("%s_%d", sessionPrefix, time.Now().UnixNano())
+	manager := NewRedisSessionManager(redisClient, prefix, 10000, 3600000, 10, 0, false)
+	manager.SetScopeLimits(ScopePolicy{
+		Owner: &ScopeLimit{MaxCallPerWindow: 3},
+	})
+	defer manager.DeleteSession(context.TODO(), owner)
+
+	sessionId, _ := manager.StartSession(context.TODO(), owner)
+	for i := 0; i < 2; i++ {
+		_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+		assert.Nil(t, errRecord, "Call under owner limit allowed")
+	}
+
+	//Restarting session doesn't reset owner counter
+	manager.DeleteSession(context.TODO(), owner)
+	sessionId, _ = manager.StartSession(context.TODO(), owner)
+	_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url2")
+	assert.Nil(t, errRecord, "Last call under owner limit allowed")
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId, owner, "url2")
+	assert.True(t, errors.Is(errRecord, ErrTooMany), "Call over owner limit rejected")
+	var scopeError *ScopeError
+	assert.True(t, errors.As(errRecord, &scopeError), "Scope reported")
+	assert.Equal(t, ScopeOwner, scopeError.Scope, "Owner scope tripped")
+	assert.Equal(t, owner, scopeError.Key, "Owner reported")
+
+	calls, errGet := manager.GetScopeCalls(context.TODO(), ScopeOwner, owner)
+	assert.Nil(t, errGet, "Get scope calls, no error")
+	assert.Equal(t, int64(3), calls, "Rejected call not counted")
+	session, _ := manager.GetSession(context.TODO(), owner)
+	assert.Equal(t, int64(1), session.GetCallRecord("url2").Count, "Rejected call not counted in session")
+}
+
+// go test -timeout 30s -run ^TestScopes_OrganizationAndGlobal$ github.com/zeroboo/go-api-session -v
+func TestScopes_OrganizationAndGlobal(t *testing.T) {
+	prefix := fmt.Sprintf("%s_%d", sessionPrefix, time.Now().UnixNano())
+	manager := NewRedisSessionManager(redisClient, prefix, 10000, 3600000, 10, 0, false)
+	manager.SetScopeLimits(ScopePolicy{
+		Organization:    &ScopeLimit{MaxCallPerWindow: 3},
+		Global:          &ScopeLimit{MaxCallPerWindow: 5},
+		OrganizationKey: "org",
+	})
+
+	start := func(owner string, organization string) string {
+		payload := map[string]any{}
+		if organization != "" {
+			payload["org"] = organization
+		}
+		session, errStart := manager.StartSessionWithPayload(context.TODO(), owner, payload)
+		assert.Nil(t, errStart, "Start session, no error")
+		t.Cleanup(func() { manager.DeleteSession(context.TODO(), owner) })
+		return session.Id
+	}
+	member1 := "user_" + t.Name() + "_1"
+	member2 := "user_" + t.Name() + "_2"
+	outsider := "user_" + t.Name() + "_3"
+	sessionId1 := start(member1, "org1")
+	sessionId2 := start(member2, "org1")
+	sessionId3 := start(outsider, "")
+
+	_, errRecord := manager.RecordAPICall(context.TODO(), sessionId1, member1, "url1")
+	assert.Nil(t, errRecord, "Member 1 call allowed")
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId2, member2, "url1")
+	assert.Nil(t, errRecord, "Member 2 call allowed")
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId2, member2, "url1")
+	assert.Nil(t, errRecord, "Last call under organization limit allowed")
+
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId1, member1, "url1")
+	var scopeError *ScopeError
+	assert.True(t, errors.As(errRecord, &scopeError), "Call over organization limit rejected")
+	assert.Equal(t, ScopeOrganization, scopeError.Scope, "Organization scope tripped")
+	assert.Equal(t, "org1", scopeError.Key, "Organization reported")
+
+	//Sessions without organization are limited by global scope only
+	for i := 0; i < 2; i++ {
+		_, errRecord = manager.RecordAPICall(context.TODO(), sessionId3, outsider, "url1")
+		assert.Nil(t, errRecord, "Call under global limit allowed")
+	}
+	_, errRecord = manager.RecordAPICall(context.TODO(), sessionId3, outsider, "url1")
+	assert.True(t, errors.As(errRecord, &scopeError), "Call over global limit rejected")
+	assert.Equal(t, ScopeGlobal, scopeError.Scope, "Global scope tripped")
+
+	calls, _ := manager.GetScopeCalls(context.TODO(), ScopeGlobal, "")
+	assert.Equal(t, int64(5), calls, "Global calls")
+}
+
+// go test -timeout 30s -run ^TestScopes_SessionRejectionReported$ github.com/zeroboo/go-api-session -v
+func TestScopes_SessionRejectionReported(t *testing.T) {
+	owner := "user_" + t.Name()
+	prefix := fmt.Sprintf("%s_%d", sessionPrefix, time.Now().UnixNano())
+	manager := NewRedisSessionManager(redisClient, prefix, 10000, 3600000, 1, 0, false)
+	manager.SetScopeLimits(ScopePolicy{
+		Owner: &ScopeLimit{MaxCallPerWindow: 10},
+	})
+	defer manager.DeleteSession(context.TODO(), owner)
+	sessionId, _ := manager.StartSession(context.TODO(), owner)
+
+	manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	var scopeError *ScopeError
+	assert.True(t, errors.As(errRecord, &scopeError), "Session rejection reported as scope")
+	assert.Equal(t, ScopeSession, scopeError.Scope, "Session scope tripped")
+	assert.True(t, errors.Is(errRecord, ErrTooMany), "Cause matched")
+
+	_, errRecord = manager.RecordAPICall(context.TODO(), "invalid", owner, "url1")
+	assert.True(t, errors.Is(errRecord, ErrInvalidSession), "Invalid session rejected")
+	calls, _ := manager.GetScopeCalls(context.TODO(), ScopeOwner, owner)
+	assert.Equal(t, int64(1), calls, "Rejected calls not counted in owner scope")
+}
+
+// go test -timeout 30s -run ^TestGetOrganizationKey_SameForCodecs$ github.com/zeroboo/go-api-session -v
+func TestGetOrganizationKey_SameForCodecs(t *testing.T) {
+	assert.Equal(t, "7", getOrganizationKey(int64(7)), "Msgpack integer")
+	assert.Equal(t, "7", getOrganizationKey(uint8(7)), "Msgpack small integer")
+	assert.Equal(t, "7", getOrganizationKey(float64(7)), "JSON number")
+	assert.Equal(t, "acme", getOrganizationKey("acme"), "String id")
+	assert.Equal(t, "7.5", getOrganizationKey(7.5), "Fraction kept")
+}
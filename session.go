@@ -1,7 +1,9 @@
 package apisession
 
 import (
+	"bytes"
 	"fmt"
+	"maps"
 	"time"
 )
 
@@ -19,12 +21,27 @@ type APISession struct {
 
 	Created int64 `json:"c" msgpack:"c"` //Created time in milliseconds
 	Updated int64 `json:"u" msgpack:"u"` //Updated time in milliseconds
+
+	//Increased on every save, see SetSessionIfVersion
+	Version int64 `json:"v" msgpack:"v"`
+
+	//Typed payload encoded with format byte, see SetTypedPayload
+	Data []byte `json:"d,omitempty" msgpack:"d,omitempty"`
+
+	//Map of url to extra quota, see GrantQuota
+	Grants map[string]*QuotaGrant `json:"g,omitempty" msgpack:"g,omitempty"`
+
+	//Client the session was started by, see StartSessionWithClient
+	Client *ClientInfo `json:"cl,omitempty" msgpack:"cl,omitempty"`
+
+	//Codec of typed payload, set by manager
+	codec Codec
 }
 
 // Tracks how an api is being called
 type APICallRecord struct {
 
-	//calls in current window
+	//calls in current window, weighted by their costs, see SetURLCosts
 	Count int64 `json:"c" msgpack:"c"`
 
 	//Last call in milliseconds
@@ -66,6 +83,33 @@ func NewAPISessionWithPayload(owner string, payload map[string]any) *APISession
 	}
 }
 
+// Returns a copy of session, nested payload values are shared
+func (ses *APISession) clone() *APISession {
+	if ses == nil {
+		return nil
+	}
+	copied := *ses
+	copied.Records = make(map[string]*APICallRecord, len(ses.Records))
+	for url, record := range ses.Records {
+		recordCopy := *record
+		copied.Records[url] = &recordCopy
+	}
+	copied.Payload = maps.Clone(ses.Payload)
+	copied.Data = bytes.Clone(ses.Data)
+	if ses.Grants != nil {
+		copied.Grants = make(map[string]*QuotaGrant, len(ses.Grants))
+		for url, grant := range ses.Grants {
+			grantCopy := *grant
+			copied.Grants[url] = &grantCopy
+		}
+	}
+	if ses.Client != nil {
+		client := *ses.Client
+		copied.Client = &client
+	}
+	return &copied
+}
+
 func (ses *APISession) SetPayload(key string, value any) {
 	if ses.Payload == nil {
 		ses.Payload = make(map[string]any)
@@ -102,32 +146,28 @@ func (ses *APISession) GetPayloadString(key string) string {
 	return ""
 }
 
-// Returns metadata as int64, 0 if not found
+// Returns metadata as int64, 0 if not found or not an integer.
+// Any integer type is accepted since decoded sessions don't keep number types.
 func (ses *APISession) GetPayloadInt64(key string) int64 {
 	if ses.Payload == nil {
 		return 0
 	}
-	value := ses.Payload[key]
-	if value != nil {
-		intValue, isInt64 := value.(int64)
-		if isInt64 {
-			return intValue
-		}
+	value, isInt := toInt64(ses.Payload[key])
+	if isInt {
+		return value
 	}
 	return 0
 }
 
-// Returns metadata as int, 0 if not found
+// Returns metadata as int, 0 if not found or not an integer.
+// Any integer type is accepted since decoded sessions don't keep number types.
 func (ses *APISession) GetPayloadInt(key string) int {
 	if ses.Payload == nil {
 		return 0
 	}
-	value := ses.Payload[key]
-	if value != nil {
-		intValue, isInt := value.(int)
-		if isInt {
-			return intValue
-		}
+	value, isInt := convertPayloadValue[int](ses.Payload[key])
+	if isInt {
+		return value
 	}
 	return 0
 }
@@ -146,14 +186,15 @@ func (ses *APISession) ValidateSession(session string) bool {
 	return ses.Id == session
 }
 
-// GetPayloadMap returns a map from session payload, if not exist, return nil
+// GetPayloadMap returns a map from session payload, if not exist or not convertible, return nil.
+// If the stored map has another type, eg: map[string]any of a decoded session, a converted copy is returned.
 func GetPayloadMap[K comparable, V any](sess *APISession, key string) map[K]V {
 	value, exist := sess.Payload[key]
 	if !exist {
 		return nil
 	}
 
-	typedValue, ok := value.(map[K]V)
+	typedValue, ok := convertPayloadValue[map[K]V](value)
 	if !ok {
 		return nil
 	}
@@ -166,6 +207,9 @@ func GetPayloadMap[K comparable, V any](sess *APISession, key string) map[K]V {
 //
 // Return the map and a boolean indicate if the map is created
 func GetOrCreatePayloadMap[K comparable, V any](sess *APISession, key string) (map[K]V, bool) {
+	if sess.Payload == nil {
+		sess.Payload = make(map[string]any)
+	}
 	value, exist := sess.Payload[key]
 	if !exist {
 		newMap := make(map[K]V)
@@ -184,7 +228,7 @@ func GetOrCreatePayloadMap[K comparable, V any](sess *APISession, key string) (m
 	if ok {
 		newMap := make(map[K]V)
 		for k, v := range mapAny {
-			mapValue, valueOk := v.(V)
+			mapValue, valueOk := convertPayloadValue[V](v)
 			if valueOk {
 				newMap[k] = mapValue
 			}
@@ -199,33 +243,30 @@ func GetOrCreatePayloadMap[K comparable, V any](sess *APISession, key string) (m
 	return newMap, true
 
 }
+
+// GetPayloadSlice returns a slice from session payload, false if not exist or any element is not convertible to V
 func GetPayloadSlice[V any](sess *APISession, key string) ([]V, bool) {
 	value, exist := sess.Payload[key]
 	if !exist {
 		return nil, false
 	}
-	retValues := []V{}
-	for _, v := range value.([]any) {
-		typedValue, ok := v.(V)
-		if !ok {
-			return nil, false
-		}
-		retValues = append(retValues, typedValue)
-	}
-
-	return retValues, true
+	return convertPayloadValue[[]V](value)
 }
 
+// GetOrCreatePayloadSlice returns a slice from session payload, if not exist, create a new empty slice.
+// Returns true if an existing value is returned, false if the slice is created or the value is not convertible to []V.
 func GetOrCreatePayloadSlice[V any](sess *APISession, key string) ([]V, bool) {
+	if sess.Payload == nil {
+		sess.Payload = make(map[string]any)
+	}
 	value, exist := sess.Payload[key]
 	if !exist {
 		newSlice := make([]V, 0)
-		sess.Payload[key] = value
+		sess.Payload[key] = newSlice
 		return newSlice, false
 	}
 
-	typedValue, ok := value.([]V)
-	return typedValue, ok
+	return convertPayloadValue[[]V](value)
 }
 
 // SetPayloadMap init a map in session payload
@@ -6,7 +6,6 @@ import (
 	"testing"
 	"time"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -48,7 +47,7 @@ func TestValidateSession_InvalidSessionValue_Error(t *testing.T) {
 		URL:       "url1",
 	}, session, now)
 	assert.Equal(t, ErrInvalidSession, errValidate, "Invalid owner, error")
-	log.Infof("Session: %v", sessionId)
+	t.Logf("Session: %v", sessionId)
 }
 
 // go test -timeout 30s -run ^TestValidateSession_TooFast_Error$ github.com/zeroboo/go-api-session
@@ -0,0 +1,223 @@
+// Package tracing emits opentelemetry spans for operations of an apisession.ISessionManager
+package tracing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	apisession "github.com/zeroboo/go-api-session"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/zeroboo/go-api-session/tracing"
+
+const (
+	AttributeOwnerHash = attribute.Key("apisession.owner_hash")
+	AttributeURL       = attribute.Key("apisession.url")
+	AttributeDecision  = attribute.Key("apisession.decision")
+	AttributeErrorKind = attribute.Key("apisession.error_kind")
+)
+
+const (
+	DecisionAllowed  = "allowed"
+	DecisionRejected = "rejected"
+)
+
+// TracedSessionManager wraps an ISessionManager and creates a span for each operation.
+// Spans are children of the span in the caller's context.
+type TracedSessionManager struct {
+	next   apisession.ISessionManager
+	tracer trace.Tracer
+}
+
+var _ apisession.ISessionManager = (*TracedSessionManager)(nil)
+var _ apisession.APIRequestRecorder = (*TracedSessionManager)(nil)
+var _ apisession.SessionUpdater = (*TracedSessionManager)(nil)
+var _ apisession.PayloadUpdater = (*TracedSessionManager)(nil)
+var _ apisession.CounterResetter = (*TracedSessionManager)(nil)
+var _ apisession.QuotaGranter = (*TracedSessionManager)(nil)
+var _ apisession.CacheInvalidator = (*TracedSessionManager)(nil)
+
+// Creates a traced session manager
+//
+// Params:
+//   - next: the wrapped manager
+//   - provider: tracer provider, if nil the global provider is used
+func NewTracedSessionManager(next apisession.ISessionManager, provider trace.TracerProvider) *TracedSessionManager {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &TracedSessionManager{
+		next:   next,
+		tracer: provider.Tracer(tracerName),
+	}
+}
+
+func (m *TracedSessionManager) start(ctx context.Context, operation string, owner string) (context.Context, trace.Span) {
+	return m.tracer.Start(ctx, "apisession."+operation,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(AttributeOwnerHash.String(apisession.Hash(owner))))
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(AttributeErrorKind.String(apisession.ErrorKind(err)))
+		if !apisession.IsRejection(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+// Ends span of an api call with its decision
+func endCall(span trace.Span, err error) {
+	if err == nil {
+		span.SetAttributes(AttributeDecision.String(DecisionAllowed))
+	} else if apisession.IsRejection(err) {
+		span.SetAttributes(AttributeDecision.String(DecisionRejected))
+	}
+	end(span, err)
+}
+
+func (m *TracedSessionManager) RecordAPICall(ctx context.Context, sessionId string, owner string, url string) (*apisession.APISession, error) {
+	ctx, span := m.start(ctx, "RecordAPICall", owner)
+	span.SetAttributes(AttributeURL.String(url))
+	session, err := m.next.RecordAPICall(ctx, sessionId, owner, url)
+	endCall(span, err)
+	return session, err
+}
+
+// RecordAPIRequest records an api call with client and cost, see RedisSessionManager.RecordAPIRequest.
+// Falls back to RecordAPICall if the wrapped manager doesn't support requests.
+func (m *TracedSessionManager) RecordAPIRequest(ctx context.Context, request *apisession.APIRequest) (*apisession.APISession, error) {
+	ctx, span := m.start(ctx, "RecordAPIRequest", request.Owner)
+	span.SetAttributes(AttributeURL.String(request.URL))
+	var session *apisession.APISession
+	var err error
+	if recorder, ok := m.next.(apisession.APIRequestRecorder); ok {
+		session, err = recorder.RecordAPIRequest(ctx, request)
+	} else {
+		session, err = m.next.RecordAPICall(ctx, request.SessionId, request.Owner, request.URL)
+	}
+	endCall(span, err)
+	return session, err
+}
+
+func (m *TracedSessionManager) ValidateAPICall(request *apisession.APIRequest, session *apisession.APISession, now time.Time) error {
+	return m.next.ValidateAPICall(request, session, now)
+}
+
+func (m *TracedSessionManager) GetSession(ctx context.Context, owner string) (*apisession.APISession, error) {
+	ctx, span := m.start(ctx, "GetSession", owner)
+	session, err := m.next.GetSession(ctx, owner)
+	end(span, err)
+	return session, err
+}
+
+func (m *TracedSessionManager) DeleteSession(ctx context.Context, owner string) error {
+	ctx, span := m.start(ctx, "DeleteSession", owner)
+	err := m.next.DeleteSession(ctx, owner)
+	end(span, err)
+	return err
+}
+
+func (m *TracedSessionManager) SetSession(ctx context.Context, owner string, session *apisession.APISession) error {
+	ctx, span := m.start(ctx, "SetSession", owner)
+	err := m.next.SetSession(ctx, owner, session)
+	end(span, err)
+	return err
+}
+
+func (m *TracedSessionManager) StartSession(ctx context.Context, owner string) (string, error) {
+	ctx, span := m.start(ctx, "StartSession", owner)
+	sessionId, err := m.next.StartSession(ctx, owner)
+	end(span, err)
+	return sessionId, err
+}
+
+func (m *TracedSessionManager) StartSessionWithPayload(ctx context.Context, owner string, payload map[string]any) (*apisession.APISession, error) {
+	ctx, span := m.start(ctx, "StartSessionWithPayload", owner)
+	session, err := m.next.StartSessionWithPayload(ctx, owner, payload)
+	end(span, err)
+	return session, err
+}
+
+// UpdateSession atomically modifies session of owner, see RedisSessionManager.UpdateSession.
+// Returns errors.ErrUnsupported if the wrapped manager doesn't support it.
+func (m *TracedSessionManager) UpdateSession(ctx context.Context, owner string, mutate func(session *apisession.APISession) error) (*apisession.APISession, error) {
+	updater, ok := m.next.(apisession.SessionUpdater)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	ctx, span := m.start(ctx, "UpdateSession", owner)
+	session, err := updater.UpdateSession(ctx, owner, mutate)
+	end(span, err)
+	return session, err
+}
+
+// UpdatePayload atomically modifies payload of session of owner, see RedisSessionManager.UpdatePayload.
+// Returns errors.ErrUnsupported if the wrapped manager doesn't support it.
+func (m *TracedSessionManager) UpdatePayload(ctx context.Context, owner string, update func(payload map[string]any)) error {
+	updater, ok := m.next.(apisession.PayloadUpdater)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	ctx, span := m.start(ctx, "UpdatePayload", owner)
+	err := updater.UpdatePayload(ctx, owner, update)
+	end(span, err)
+	return err
+}
+
+// ResetCounters clears api call counters of owner, see RedisSessionManager.ResetCounters.
+// Returns errors.ErrUnsupported if the wrapped manager doesn't support it.
+func (m *TracedSessionManager) ResetCounters(ctx context.Context, owner string, url string) error {
+	resetter, ok := m.next.(apisession.CounterResetter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	ctx, span := m.start(ctx, "ResetCounters", owner)
+	err := resetter.ResetCounters(ctx, owner, url)
+	end(span, err)
+	return err
+}
+
+// GrantQuota gives owner extra calls, see RedisSessionManager.GrantQuota.
+// Returns errors.ErrUnsupported if the wrapped manager doesn't support it.
+func (m *TracedSessionManager) GrantQuota(ctx context.Context, owner string, url string, calls int64, duration time.Duration) error {
+	granter, ok := m.next.(apisession.QuotaGranter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	ctx, span := m.start(ctx, "GrantQuota", owner)
+	err := granter.GrantQuota(ctx, owner, url, calls, duration)
+	end(span, err)
+	return err
+}
+
+// EnableCacheInvalidation enables cache invalidation of the wrapped manager if it supports it
+func (m *TracedSessionManager) EnableCacheInvalidation() {
+	if invalidator, ok := m.next.(apisession.CacheInvalidator); ok {
+		invalidator.EnableCacheInvalidation()
+	}
+}
+
+func (m *TracedSessionManager) GetRequestInterval() int64 {
+	return m.next.GetRequestInterval()
+}
+
+func (m *TracedSessionManager) GetMaxCallPerWindow() int64 {
+	return m.next.GetMaxCallPerWindow()
+}
+
+func (m *TracedSessionManager) GetWindowSize() int64 {
+	return m.next.GetWindowSize()
+}
+
+func (m *TracedSessionManager) GetOnlineUsers(ctx context.Context) (map[string]int64, error) {
+	return m.next.GetOnlineUsers(ctx)
+}
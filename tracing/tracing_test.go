@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apisession "github.com/zeroboo/go-api-session"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Returns preset results instead of calling redis
+type stubSessionManager struct {
+	apisession.ISessionManager
+	err error
+}
+
+func (s *stubSessionManager) RecordAPICall(ctx context.Context, sessionId string, owner string, url string) (*apisession.APISession, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return apisession.NewAPISession(owner), nil
+}
+
+func (s *stubSessionManager) RecordAPIRequest(ctx context.Context, request *apisession.APIRequest) (*apisession.APISession, error) {
+	return s.RecordAPICall(ctx, request.SessionId, request.Owner, request.URL)
+}
+
+func (s *stubSessionManager) ResetCounters(ctx context.Context, owner string, url string) error {
+	return s.err
+}
+
+func (s *stubSessionManager) DeleteSession(ctx context.Context, owner string) error {
+	return s.err
+}
+
+func newTestManager(err error) (*TracedSessionManager, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return NewTracedSessionManager(&stubSessionManager{err: err}, provider), exporter
+}
+
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]string {
+	values := make(map[attribute.Key]string)
+	for _, kv := range span.Attributes {
+		values[kv.Key] = kv.Value.AsString()
+	}
+	return values
+}
+
+// go test -timeout 30s -run ^TestTraced_RecordAPICall_Rejected$ github.com/zeroboo/go-api-session/tracing -v
+func TestTraced_RecordAPICall_Rejected(t *testing.T) {
+	manager, exporter := newTestManager(apisession.ErrTooMany)
+
+	_, errRecord := manager.RecordAPICall(context.TODO(), "id", "user1", "url1")
+	assert.Equal(t, apisession.ErrTooMany, errRecord, "Error is passed through")
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 1, len(spans), "One span")
+	assert.Equal(t, "apisession.RecordAPICall", spans[0].Name, "Span name")
+	attributes := spanAttributes(spans[0])
+	assert.Equal(t, apisession.Hash("user1"), attributes[AttributeOwnerHash], "Owner is hashed")
+	assert.Equal(t, "url1", attributes[AttributeURL], "Url attribute")
+	assert.Equal(t, DecisionRejected, attributes[AttributeDecision], "Decision attribute")
+	assert.Equal(t, "too_many", attributes[AttributeErrorKind], "Error kind attribute")
+	assert.Equal(t, codes.Unset, spans[0].Status.Code, "Rejection is not a span error")
+}
+
+// go test -timeout 30s -run ^TestTraced_ChildOfCallerSpan$ github.com/zeroboo/go-api-session/tracing -v
+func TestTraced_ChildOfCallerSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	manager := NewTracedSessionManager(&stubSessionManager{err: context.DeadlineExceeded}, provider)
+
+	ctx, parent := provider.Tracer("test").Start(context.TODO(), "handler")
+	manager.DeleteSession(ctx, "user1")
+	parent.End()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 2, len(spans), "Child and parent spans")
+	assert.Equal(t, "apisession.DeleteSession", spans[0].Name, "Child span name")
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent.SpanID(), "Child of caller span")
+	assert.Equal(t, codes.Error, spans[0].Status.Code, "Failure is a span error")
+	assert.Equal(t, "canceled", spanAttributes(spans[0])[AttributeErrorKind], "Error kind attribute")
+}
+
+// go test -timeout 30s -run ^TestTraced_RecordAPIRequest_Allowed$ github.com/zeroboo/go-api-session/tracing -v
+func TestTraced_RecordAPIRequest_Allowed(t *testing.T) {
+	manager, exporter := newTestManager(nil)
+
+	_, errRecord := manager.RecordAPIRequest(context.TODO(), &apisession.APIRequest{Owner: "user1", SessionId: "id", URL: "url1", Cost: 3})
+	assert.Nil(t, errRecord, "Request allowed")
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 1, len(spans), "One span")
+	assert.Equal(t, "apisession.RecordAPIRequest", spans[0].Name, "Span name")
+	attributes := spanAttributes(spans[0])
+	assert.Equal(t, "url1", attributes[AttributeURL], "Url attribute")
+	assert.Equal(t, DecisionAllowed, attributes[AttributeDecision], "Decision attribute")
+}
+
+// go test -timeout 30s -run ^TestTraced_ResetCounters_Forwarded$ github.com/zeroboo/go-api-session/tracing -v
+func TestTraced_ResetCounters_Forwarded(t *testing.T) {
+	manager, exporter := newTestManager(nil)
+
+	errReset := manager.ResetCounters(context.TODO(), "user1", "url1")
+	assert.Nil(t, errReset, "Reset, no error")
+	errGrant := manager.GrantQuota(context.TODO(), "user1", "url1", 10, time.Minute)
+	assert.ErrorIs(t, errGrant, errors.ErrUnsupported, "Wrapped manager can't grant")
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 1, len(spans), "One span")
+	assert.Equal(t, "apisession.ResetCounters", spans[0].Name, "Span name")
+}
@@ -0,0 +1,102 @@
+package apisession
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// PayloadValidator is implemented by typed payloads validated when set and loaded
+type PayloadValidator interface {
+	Validate() error
+}
+
+var ErrInvalidPayload = fmt.Errorf("invalid payload")
+
+func validatePayload[T any](payload *T) error {
+	validator, ok := any(payload).(PayloadValidator)
+	if !ok {
+		validator, ok = any(*payload).(PayloadValidator)
+	}
+	if !ok {
+		return nil
+	}
+	errValidate := validator.Validate()
+	if errValidate != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, errValidate)
+	}
+	return nil
+}
+
+// SetTypedPayload stores a struct as typed payload of the session, encoded with codec of
+// the manager loaded the session (msgpack for sessions not from a manager).
+// ProtobufCodec only encodes proto.Message payloads, other payloads are encoded with msgpack.
+// Typed payload is separated from the map payload, both can be used.
+//
+// Returns:
+//   - error: ErrInvalidPayload if payload implements PayloadValidator and is invalid, or encoding error
+func SetTypedPayload[T any](sess *APISession, payload T) error {
+	errValidate := validatePayload(&payload)
+	if errValidate != nil {
+		return errValidate
+	}
+	codec := getTypedPayloadCodec(sess.codec, payload)
+	data, errEncode := EncodeValue(codec, payload)
+	if errEncode != nil {
+		return errEncode
+	}
+	sess.Data = data
+	return nil
+}
+
+// GetTypedPayload decodes typed payload of the session set by SetTypedPayload
+//
+// Returns:
+//   - payload T: decoded payload
+//   - error: ErrPayloadNotFound if session has no typed payload,
+//     ErrInvalidPayload if payload implements PayloadValidator and is invalid, or decoding error
+func GetTypedPayload[T any](sess *APISession) (T, error) {
+	var payload T
+	if len(sess.Data) == 0 {
+		return payload, ErrPayloadNotFound
+	}
+	errDecode := DecodeValue(sess.Data, &payload)
+	if errDecode != nil {
+		return payload, errDecode
+	}
+	return payload, validatePayload(&payload)
+}
+
+// Returns codec encoding a typed payload, payload is decoded by its format byte whatever codec it is
+func getTypedPayloadCodec(codec Codec, payload any) Codec {
+	if codec == nil {
+		return MsgpackCodec{}
+	}
+	if _, isProtobuf := codec.(ProtobufCodec); isProtobuf {
+		if _, isMessage := payload.(proto.Message); !isMessage {
+			return MsgpackCodec{}
+		}
+	}
+	return codec
+}
+
+// StartSessionWithTypedPayload creates a new session with typed payload for the owner and insert to db
+//
+// Returns:
+//   - session *APISession: new session with payload
+//   - error: error if exists, nil is successful
+func StartSessionWithTypedPayload[T any](ctx context.Context, sm *RedisSessionManager, owner string, payload T) (*APISession, error) {
+	session := NewAPISession(owner)
+	//Payload is encoded by codec of the manager
+	session.codec = sm.codec
+	errPayload := SetTypedPayload(session, payload)
+	if errPayload != nil {
+		return nil, errPayload
+	}
+	errStart := sm.startSession(ctx, owner, session)
+	if errStart != nil {
+		return nil, errStart
+	}
+	return session, nil
+}
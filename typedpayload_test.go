@@ -0,0 +1,74 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testProfile struct {
+	Nickname string   `json:"nickname" msgpack:"nickname"`
+	Level    int      `json:"level" msgpack:"level"`
+	Tokens   []string `json:"tokens" msgpack:"tokens"`
+}
+
+func (p testProfile) Validate() error {
+	if p.Level < 0 {
+		return fmt.Errorf("negative level")
+	}
+	return nil
+}
+
+// go test -timeout 30s -run ^TestTypedPayload_StartAndLoad_Correct$ github.com/zeroboo/go-api-session -v
+func TestTypedPayload_StartAndLoad_Correct(t *testing.T) {
+	for _, codec := range []Codec{MsgpackCodec{}, JSONCodec{}, CBORCodec{}} {
+		owner := fmt.Sprintf("user_%v_%T", t.Name(), codec)
+		manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+		manager.SetCodec(codec)
+		profile := testProfile{Nickname: "Jaian", Level: 3, Tokens: []string{"token1"}}
+
+		_, errStart := StartSessionWithTypedPayload(context.TODO(), manager, owner, profile)
+		assert.Nil(t, errStart, "Start session, no error: %T", codec)
+		sessionOwners = append(sessionOwners, owner)
+
+		session, errGet := manager.GetSession(context.TODO(), owner)
+		assert.Nil(t, errGet, "Get session, no error: %T", codec)
+		loaded, errPayload := GetTypedPayload[testProfile](session)
+		assert.Nil(t, errPayload, "Get typed payload, no error: %T", codec)
+		assert.Equal(t, profile, loaded, "Typed payload: %T", codec)
+		assert.Equal(t, codec.Format(), session.Data[0], "Encoded with manager codec: %T", codec)
+	}
+}
+
+// go test -timeout 30s -run ^TestTypedPayload_ProtobufCodec_PlainStruct$ github.com/zeroboo/go-api-session -v
+func TestTypedPayload_ProtobufCodec_PlainStruct(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	manager.SetCodec(ProtobufCodec{})
+	profile := testProfile{Nickname: "Jaian", Level: 3, Tokens: []string{"token1"}}
+
+	_, errStart := StartSessionWithTypedPayload(context.TODO(), manager, owner, profile)
+	assert.Nil(t, errStart, "Start session, no error")
+	sessionOwners = append(sessionOwners, owner)
+
+	session, errGet := manager.GetSession(context.TODO(), owner)
+	assert.Nil(t, errGet, "Get session, no error")
+	loaded, errPayload := GetTypedPayload[testProfile](session)
+	assert.Nil(t, errPayload, "Get typed payload, no error")
+	assert.Equal(t, profile, loaded, "Typed payload")
+	assert.Equal(t, FormatMsgpack, session.Data[0], "Plain struct encoded with msgpack")
+}
+
+// go test -timeout 30s -run ^TestTypedPayload_Invalid_Error$ github.com/zeroboo/go-api-session -v
+func TestTypedPayload_Invalid_Error(t *testing.T) {
+	session := NewAPISession("user1")
+	_, errMissing := GetTypedPayload[testProfile](session)
+	assert.True(t, errors.Is(errMissing, ErrPayloadNotFound), "No typed payload, not found error")
+
+	errSet := SetTypedPayload(session, testProfile{Level: -1})
+	assert.True(t, errors.Is(errSet, ErrInvalidPayload), "Invalid payload, error")
+	assert.Empty(t, session.Data, "Invalid payload is not set")
+}
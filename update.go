@@ -0,0 +1,162 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Max attempts of an optimistic update before returning ErrConflict
+const maxUpdateAttempts = 10
+
+// Runs fn under WATCH of key, retries while the transaction fails because key was modified.
+// Returns ErrConflict when attempts are exhausted, or error of fn.
+func watchRetry(ctx context.Context, redisClient *redis.Client, key string, fn func(tx *redis.Tx) error) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		errWatch := redisClient.Watch(ctx, fn, key)
+		if !errors.Is(errWatch, redis.TxFailedErr) {
+			return errWatch
+		}
+	}
+	return ErrConflict
+}
+
+// Runs fn under WATCH of key like watchRetry, logs exhausted attempts
+func (sm *RedisSessionManager) retryWatch(ctx context.Context, key string, fn func(tx *redis.Tx) error) error {
+	errWatch := watchRetry(ctx, sm.redisClient, key, fn)
+	if errWatch == ErrConflict {
+		sm.logger.WarnContext(ctx, "session update conflicted", "key", key, "attempts", maxUpdateAttempts)
+	}
+	return errWatch
+}
+
+// Loads session under WATCH, mutates and saves it only if no one else saved it in between,
+// retries on conflict. Errors from mutate are returned as is without retrying.
+// See saveSession for touch.
+func (sm *RedisSessionManager) watchSession(ctx context.Context, owner string, mutate func(session *APISession) error, touch bool) (*APISession, error) {
+	var session *APISession
+	var errMutate error
+	errWatch := sm.retryWatch(ctx, sm.GetSessionKey(owner), func(tx *redis.Tx) error {
+		errMutate = nil
+		var errLoad error
+		session, errLoad = sm.loadSession(ctx, tx, owner)
+		if errLoad != nil {
+			return errLoad
+		}
+
+		errMutate = mutate(session)
+		if errMutate != nil {
+			return errMutate
+		}
+		return sm.saveSession(ctx, tx, owner, session, touch)
+	})
+
+	if errMutate != nil {
+		return nil, errMutate
+	}
+	if errWatch != nil {
+		if !errors.Is(errWatch, ErrConflict) {
+			sm.logLoadError(ctx, "UpdateSession", owner, errWatch)
+		}
+		return nil, errWatch
+	}
+	return session, nil
+}
+
+// UpdateSession atomically loads, mutates and saves session of a user, retrying on concurrent
+// modification. Session TTL is kept.
+//
+// Params:
+//   - owner: owner of the session
+//   - mutate: changes session in place, can be called more than once. Returning an error aborts the update
+//
+// Returns:
+//   - session: saved session
+//   - error: nil if success, error from mutate, ErrConflict if session keeps being modified,
+//     redis.Nil if session doesn't exist
+func (sm *RedisSessionManager) UpdateSession(ctx context.Context, owner string, mutate func(session *APISession) error) (*APISession, error) {
+	session, errUpdate := sm.watchSession(ctx, owner, mutate, false)
+	if errUpdate != nil {
+		return nil, errUpdate
+	}
+	sm.invalidateSession(ctx, owner)
+	return session, nil
+}
+
+// SetSessionIfVersion saves session only if stored session has the expected version,
+// 0 means session must not exist. On success session.Version is the new version.
+//
+// Returns:
+//   - error: nil if success, ErrConflict if stored version is different
+func (sm *RedisSessionManager) SetSessionIfVersion(ctx context.Context, owner string, session *APISession, version int64) error {
+	errWatch := sm.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		storedVersion := int64(0)
+		stored, errLoad := sm.loadSession(ctx, tx, owner)
+		if errLoad == nil {
+			storedVersion = stored.Version
+		} else if !errors.Is(errLoad, redis.Nil) {
+			return errLoad
+		}
+		if storedVersion != version {
+			return ErrConflict
+		}
+
+		session.Version = version
+		return sm.saveSession(ctx, tx, owner, session, true)
+	}, sm.GetSessionKey(owner))
+
+	if errors.Is(errWatch, redis.TxFailedErr) {
+		return ErrConflict
+	}
+	if errWatch != nil {
+		if !errors.Is(errWatch, ErrConflict) {
+			sm.logLoadError(ctx, "SetSessionIfVersion", owner, errWatch)
+		}
+		return errWatch
+	}
+	sm.invalidateSession(ctx, owner)
+	return nil
+}
+
+// UpdatePayload atomically changes payload of a session: only the payload is changed by update,
+// so api call counters saved concurrently are not overwritten. Retries on concurrent modification.
+//
+// Params:
+//   - owner: owner of the session
+//   - update: changes payload in place, can be called more than once
+//
+// Returns:
+//   - error: nil if success, ErrConflict if session keeps being modified, redis.Nil if session doesn't exist
+func (sm *RedisSessionManager) UpdatePayload(ctx context.Context, owner string, update func(payload map[string]any)) error {
+	if sm.layout == LayoutHash {
+		errUpdate := sm.updatePayloadHash(ctx, owner, update)
+		if errUpdate != nil {
+			return errUpdate
+		}
+		sm.invalidateSession(ctx, owner)
+		return nil
+	}
+	_, errUpdate := sm.UpdateSession(ctx, owner, func(session *APISession) error {
+		if session.Payload == nil {
+			session.Payload = make(map[string]any)
+		}
+		update(session.Payload)
+		return nil
+	})
+	return errUpdate
+}
+
+// SetPayloadField atomically sets one payload field of a session, see UpdatePayload
+func (sm *RedisSessionManager) SetPayloadField(ctx context.Context, owner string, key string, value any) error {
+	return sm.UpdatePayload(ctx, owner, func(payload map[string]any) {
+		payload[key] = value
+	})
+}
+
+// DeletePayloadField atomically deletes one payload field of a session, see UpdatePayload
+func (sm *RedisSessionManager) DeletePayloadField(ctx context.Context, owner string, key string) error {
+	return sm.UpdatePayload(ctx, owner, func(payload map[string]any) {
+		delete(payload, key)
+	})
+}
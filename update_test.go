@@ -0,0 +1,150 @@
+package apisession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestUpdatePayload_KeepsCounters$ github.com/zeroboo/go-api-session -v
+func TestUpdatePayload_KeepsCounters(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	sessionId, _ := manager.StartSession(context.TODO(), owner)
+	sessionOwners = append(sessionOwners, owner)
+
+	manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+	errSet := manager.SetPayloadField(context.TODO(), owner, "nickname", "Jaian")
+	assert.Nil(t, errSet, "Set payload field, no error")
+	errSet = manager.SetPayloadField(context.TODO(), owner, "level", 3)
+	assert.Nil(t, errSet, "Set payload field, no error")
+	errDelete := manager.DeletePayloadField(context.TODO(), owner, "level")
+	assert.Nil(t, errDelete, "Delete payload field, no error")
+
+	session, _ := manager.GetSession(context.TODO(), owner)
+	assert.Equal(t, "Jaian", session.GetPayloadString("nickname"), "Payload field set")
+	assert.Nil(t, session.GetPayload("level"), "Payload field deleted")
+	assert.Equal(t, int64(1), session.GetCallRecord("url1").Count, "Counter kept")
+
+	errMissing := manager.SetPayloadField(context.TODO(), "user_not_exist", "nickname", "Jaian")
+	assert.True(t, errors.Is(errMissing, redis.Nil), "Missing session, error")
+}
+
+// go test -timeout 30s -run ^TestUpdatePayload_Concurrent_NoLostUpdate$ github.com/zeroboo/go-api-session -v
+func TestUpdatePayload_Concurrent_NoLostUpdate(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	manager.StartSession(context.TODO(), owner)
+	sessionOwners = append(sessionOwners, owner)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errSet := manager.SetPayloadField(context.TODO(), owner, fmt.Sprintf("key%d", i), i)
+			assert.Nil(t, errSet, "Set payload field, no error")
+		}(i)
+	}
+	wg.Wait()
+
+	session, _ := manager.GetSession(context.TODO(), owner)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, i, session.GetPayloadInt(fmt.Sprintf("key%d", i)), "No lost update")
+	}
+}
+
+// go test -timeout 30s -run ^TestRecordAPICall_Concurrent_KeepsPayloadUpdate$ github.com/zeroboo/go-api-session -v
+func TestRecordAPICall_Concurrent_KeepsPayloadUpdate(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 100, 0, false)
+	sessionId, _ := manager.StartSession(context.TODO(), owner)
+	sessionOwners = append(sessionOwners, owner)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+			assert.Nil(t, errRecord, "Record api call, no error")
+		}()
+		go func(i int) {
+			defer wg.Done()
+			errSet := manager.SetPayloadField(context.TODO(), owner, fmt.Sprintf("key%d", i), i)
+			assert.Nil(t, errSet, "Set payload field, no error")
+		}(i)
+	}
+	wg.Wait()
+
+	session, _ := manager.GetSession(context.TODO(), owner)
+	assert.Equal(t, int64(5), session.GetCallRecord("url1").Count, "No lost api call")
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, i, session.GetPayloadInt(fmt.Sprintf("key%d", i)), "No lost payload update")
+	}
+}
+
+// go test -timeout 30s -run ^TestSetSessionIfVersion_StaleVersion_Conflict$ github.com/zeroboo/go-api-session -v
+func TestSetSessionIfVersion_StaleVersion_Conflict(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 10, 0, false)
+	redisClient.Del(context.TODO(), manager.GetSessionKey(owner))
+
+	session := NewAPISession(owner)
+	errCreate := manager.SetSessionIfVersion(context.TODO(), owner, session, 0)
+	assert.Nil(t, errCreate, "Create with version 0, no error")
+	sessionOwners = append(sessionOwners, owner)
+	assert.Equal(t, int64(1), session.Version, "First version")
+
+	first, _ := manager.GetSession(context.TODO(), owner)
+	second, _ := manager.GetSession(context.TODO(), owner)
+
+	first.SetPayload("writer", "first")
+	errFirst := manager.SetSessionIfVersion(context.TODO(), owner, first, first.Version)
+	assert.Nil(t, errFirst, "First writer, no error")
+	assert.Equal(t, int64(2), first.Version, "Version increased")
+
+	second.SetPayload("writer", "second")
+	errSecond := manager.SetSessionIfVersion(context.TODO(), owner, second, second.Version)
+	assert.True(t, errors.Is(errSecond, ErrConflict), "Second writer has stale version, conflict")
+
+	stored, _ := manager.GetSession(context.TODO(), owner)
+	assert.Equal(t, "first", stored.GetPayloadString("writer"), "First write kept")
+}
+
+// go test -timeout 30s -run ^TestUpdateSession_ConcurrentRecordAndUpdate_NoLostUpdate$ github.com/zeroboo/go-api-session -v
+func TestUpdateSession_ConcurrentRecordAndUpdate_NoLostUpdate(t *testing.T) {
+	owner := "user_" + t.Name()
+	manager := NewRedisSessionManager(redisClient, sessionPrefix, 10000, 10000, 100, 0, false)
+	sessionId, _ := manager.StartSession(context.TODO(), owner)
+	sessionOwners = append(sessionOwners, owner)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, errRecord := manager.RecordAPICall(context.TODO(), sessionId, owner, "url1")
+			assert.Nil(t, errRecord, "Record api call, no error")
+		}()
+		go func(i int) {
+			defer wg.Done()
+			_, errUpdate := manager.UpdateSession(context.TODO(), owner, func(session *APISession) error {
+				session.SetPayload(fmt.Sprintf("key%d", i), i)
+				return nil
+			})
+			assert.Nil(t, errUpdate, "Update session, no error")
+		}(i)
+	}
+	wg.Wait()
+
+	session, _ := manager.GetSession(context.TODO(), owner)
+	assert.Equal(t, int64(5), session.GetCallRecord("url1").Count, "No lost api call")
+	assert.Equal(t, 5, len(session.Payload), "No lost payload update")
+	assert.Equal(t, int64(11), session.Version, "Version increased by every write")
+}